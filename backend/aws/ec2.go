@@ -0,0 +1,162 @@
+package aws
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ec2APIVersion = "2016-11-15"
+
+type ec2Client struct {
+	client      *http.Client
+	credentials *credentialsProvider
+	endpoint    string
+	region      string
+}
+
+type ec2Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *ec2Error) Error() string {
+	return fmt.Sprintf("ec2: %s: %s", e.Code, e.Message)
+}
+
+type ec2ErrorResponse struct {
+	Errors []ec2Error `xml:"Errors>Error"`
+}
+
+type ec2Route struct {
+	DestinationCidrBlock string `xml:"destinationCidrBlock"`
+	GatewayID            string `xml:"gatewayId"`
+	InstanceID           string `xml:"instanceId"`
+	NetworkInterfaceID   string `xml:"networkInterfaceId"`
+	Origin               string `xml:"origin"`
+	State                string `xml:"state"`
+}
+
+type ec2RouteTable struct {
+	RouteTableID string     `xml:"routeTableId"`
+	Routes       []ec2Route `xml:"routeSet>item"`
+}
+
+type describeRouteTablesResponse struct {
+	RouteTables []ec2RouteTable `xml:"routeTableSet>item"`
+}
+
+type ec2NetworkInterface struct {
+	NetworkInterfaceID string                `xml:"networkInterfaceId"`
+	InstanceID         string                `xml:"attachment>instanceId"`
+	PrivateIPAddresses []ec2PrivateIPAddress `xml:"privateIpAddressesSet>item"`
+}
+
+type ec2PrivateIPAddress struct {
+	PrivateIPAddress string `xml:"privateIpAddress"`
+	PublicIP         string `xml:"association>publicIp"`
+}
+
+type describeNetworkInterfacesResponse struct {
+	NetworkInterfaces []ec2NetworkInterface `xml:"networkInterfaceSet>item"`
+}
+
+type returnResponse struct {
+	Return bool `xml:"return"`
+}
+
+func isEC2Error(err error, code string) bool {
+	e, ok := err.(*ec2Error)
+	return ok && e.Code == code
+}
+
+func (c *ec2Client) describeRouteTables(ids []string) ([]ec2RouteTable, error) {
+	params := url.Values{}
+	for i, id := range ids {
+		params.Set("RouteTableId."+strconv.Itoa(i+1), id)
+	}
+	var resp describeRouteTablesResponse
+	if err := c.do("DescribeRouteTables", params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.RouteTables, nil
+}
+
+// describeNetworkInterfaces returns the network interfaces for which filter
+// matches any of values.
+func (c *ec2Client) describeNetworkInterfaces(filter string, values []string) ([]ec2NetworkInterface, error) {
+	params := url.Values{}
+	params.Set("Filter.1.Name", filter)
+	for i, value := range values {
+		params.Set("Filter.1.Value."+strconv.Itoa(i+1), value)
+	}
+	var resp describeNetworkInterfacesResponse
+	if err := c.do("DescribeNetworkInterfaces", params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.NetworkInterfaces, nil
+}
+
+func (c *ec2Client) createRoute(table, cidr, eni string) error {
+	return c.do("CreateRoute", routeParams(table, cidr, eni), &returnResponse{})
+}
+
+func (c *ec2Client) replaceRoute(table, cidr, eni string) error {
+	return c.do("ReplaceRoute", routeParams(table, cidr, eni), &returnResponse{})
+}
+
+func (c *ec2Client) deleteRoute(table, cidr string) error {
+	err := c.do("DeleteRoute", routeParams(table, cidr, ""), &returnResponse{})
+	if isEC2Error(err, "InvalidRoute.NotFound") {
+		return nil
+	}
+	return err
+}
+
+func routeParams(table, cidr, eni string) url.Values {
+	params := url.Values{}
+	params.Set("RouteTableId", table)
+	params.Set("DestinationCidrBlock", cidr)
+	if eni != "" {
+		params.Set("NetworkInterfaceId", eni)
+	}
+	return params
+}
+
+func (c *ec2Client) do(action string, params url.Values, v interface{}) error {
+	params.Set("Action", action)
+	params.Set("Version", ec2APIVersion)
+	body := []byte(params.Encode())
+	req, err := http.NewRequest("POST", c.endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds, err := c.credentials.get()
+	if err != nil {
+		return err
+	}
+	signV4(req, body, creds, c.region, "ec2", time.Now().UTC())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp ec2ErrorResponse
+		if xml.Unmarshal(data, &errResp) == nil && len(errResp.Errors) > 0 {
+			return &errResp.Errors[0]
+		}
+		return fmt.Errorf("ec2: %s: %s", action, resp.Status)
+	}
+	return xml.Unmarshal(data, v)
+}
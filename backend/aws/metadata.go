@@ -0,0 +1,138 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type credentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// credentialsProvider returns static credentials from the environment when
+// set, and otherwise the instance role credentials from the metadata
+// server, refreshing them shortly before they expire.
+type credentialsProvider struct {
+	mu    sync.Mutex
+	creds *credentials
+}
+
+func newCredentialsProvider() *credentialsProvider {
+	p := &credentialsProvider{}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		p.creds = &credentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	return p
+}
+
+func (p *credentialsProvider) get() (*credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.creds != nil && (p.creds.Expiration.IsZero() || time.Now().Add(5*time.Minute).Before(p.creds.Expiration)) {
+		return p.creds, nil
+	}
+	creds, err := credentialsFromMetadata()
+	if err != nil {
+		return nil, err
+	}
+	p.creds = creds
+	return creds, nil
+}
+
+func credentialsFromMetadata() (*credentials, error) {
+	roles, err := metadataGet("/iam/security-credentials/")
+	if err != nil {
+		return nil, err
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return nil, fmt.Errorf("aws: no instance role found in metadata")
+	}
+	data, err := metadataGet("/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, err
+	}
+	var creds credentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+func regionFromMetadata() (string, error) {
+	zone, err := metadataGet("/placement/availability-zone")
+	if err != nil {
+		return "", err
+	}
+	if len(zone) < 2 {
+		return "", fmt.Errorf("aws: invalid availability zone %q", zone)
+	}
+	return zone[:len(zone)-1], nil
+}
+
+// metadataTokenTTL is the lifetime of the IMDSv2 session tokens, which are
+// fetched for every request.
+const metadataTokenTTL = 60
+
+// metadataGet reads path from the instance metadata service with an IMDSv2
+// session token, which works whether or not IMDSv1 is disabled.
+func metadataGet(path string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	token, err := metadataToken(client)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", metadataEndpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aws: metadata %s: %s", path, resp.Status)
+	}
+	return string(data), nil
+}
+
+func metadataToken(client *http.Client) (string, error) {
+	req, err := http.NewRequest("PUT", metadataTokenEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(metadataTokenTTL))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aws: metadata session token: %s", resp.Status)
+	}
+	return string(data), nil
+}
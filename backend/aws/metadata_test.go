@@ -0,0 +1,71 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestMetadataServer serves the instance metadata in values, which are
+// keyed by path, to requests with the session token "token" only, as with
+// IMDSv1 disabled.
+func newTestMetadataServer(t *testing.T, values map[string]string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			http.Error(w, "missing token TTL", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("token"))
+	})
+	mux.HandleFunc("/latest/meta-data/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		value, ok := values[r.URL.Path[len("/latest/meta-data"):]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(value))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	endpoint, tokenEndpoint := metadataEndpoint, metadataTokenEndpoint
+	metadataEndpoint = server.URL + "/latest/meta-data"
+	metadataTokenEndpoint = server.URL + "/latest/api/token"
+	t.Cleanup(func() {
+		metadataEndpoint, metadataTokenEndpoint = endpoint, tokenEndpoint
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	newTestMetadataServer(t, map[string]string{
+		"/placement/availability-zone":      "eu-west-1b",
+		"/iam/security-credentials/":        "flannel\n",
+		"/iam/security-credentials/flannel": `{"AccessKeyId": "AKID", "SecretAccessKey": "secret", "Token": "session", "Expiration": "2026-10-18T12:00:00Z"}`,
+	})
+	region, err := regionFromMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region != "eu-west-1" {
+		t.Errorf("got region %q, want eu-west-1", region)
+	}
+	creds, err := credentialsFromMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKID" || creds.SecretAccessKey != "secret" || creds.Token != "session" {
+		t.Errorf("got credentials %+v", creds)
+	}
+	if _, err := metadataGet("/missing"); err == nil {
+		t.Error("expected an error for a missing value")
+	}
+}
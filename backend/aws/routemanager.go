@@ -0,0 +1,362 @@
+package aws
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+var (
+	metadataEndpoint      = "http://169.254.169.254/latest/meta-data"
+	metadataTokenEndpoint = "http://169.254.169.254/latest/api/token"
+)
+
+type Config struct {
+	// ClusterCIDR is the flannel network. Routes in the route tables whose
+	// destination is a subnet of it and whose target is an instance or
+	// network interface are considered owned by the route manager.
+	ClusterCIDR string
+	// Endpoint overrides the EC2 API endpoint, e.g. for a local stand-in.
+	Endpoint string
+	// Region defaults to the region of the instance from metadata.
+	Region      string
+	RouteTables []string
+}
+
+type RouteManager struct {
+	clusterNet  *net.IPNet
+	ec2         *ec2Client
+	routeTables []string
+}
+
+func New(config *Config) (*RouteManager, error) {
+	if len(config.RouteTables) == 0 {
+		return nil, fmt.Errorf("aws: at least one route table is required")
+	}
+	_, clusterNet, err := net.ParseCIDR(config.ClusterCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("aws: invalid cluster CIDR %q: %v", config.ClusterCIDR, err)
+	}
+	region := config.Region
+	if region == "" {
+		region, err = regionFromMetadata()
+		if err != nil {
+			return nil, err
+		}
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://ec2.%s.amazonaws.com/", region)
+	}
+	rm := &RouteManager{
+		clusterNet: clusterNet,
+		ec2: &ec2Client{
+			client:      &http.Client{Timeout: 30 * time.Second},
+			credentials: newCredentialsProvider(),
+			endpoint:    endpoint,
+			region:      region,
+		},
+		routeTables: config.RouteTables,
+	}
+	return rm, nil
+}
+
+// Delete deletes the route to subnet from every route table that has an
+// owned one. Routes of other owners are left alone and reported as errors.
+func (rm *RouteManager) Delete(subnet string) (string, error) {
+	name := formatRouteName(rm.routeTables, subnet)
+	tables, err := rm.ec2.describeRouteTables(rm.routeTables)
+	if err != nil {
+		return name, err
+	}
+	var lastError error
+	for _, t := range tables {
+		r := findRoute(t, subnet)
+		if r == nil {
+			continue
+		}
+		if !rm.owned(*r) {
+			lastError = conflictError(t.RouteTableID, subnet)
+			continue
+		}
+		if err := rm.ec2.deleteRoute(t.RouteTableID, subnet); err != nil {
+			lastError = err
+		}
+	}
+	return name, lastError
+}
+
+func (rm *RouteManager) DeleteAllRoutes() ([]string, error) {
+	deleted := []string{}
+	var lastError error
+	tables, err := rm.ec2.describeRouteTables(rm.routeTables)
+	if err != nil {
+		return deleted, err
+	}
+	for _, t := range tables {
+		for _, r := range t.Routes {
+			if !rm.owned(r) {
+				continue
+			}
+			if err := rm.ec2.deleteRoute(t.RouteTableID, r.DestinationCidrBlock); err != nil {
+				lastError = err
+			}
+			deleted = append(deleted, formatRouteName([]string{t.RouteTableID}, r.DestinationCidrBlock))
+		}
+	}
+	return deleted, lastError
+}
+
+func (rm *RouteManager) Insert(ip, subnet string) (string, error) {
+	enis, err := rm.nextHops([]string{ip})
+	if err != nil {
+		return formatRouteName(rm.routeTables, subnet), err
+	}
+	eni, ok := enis[ip]
+	if !ok {
+		return formatRouteName(rm.routeTables, subnet), noNetworkInterfaceError(ip)
+	}
+	var lastError error
+	for _, table := range rm.routeTables {
+		if err := rm.insert(table, subnet, eni); err != nil {
+			lastError = err
+		}
+	}
+	return formatRouteName(rm.routeTables, subnet), lastError
+}
+
+func (rm *RouteManager) Plan(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	p, err := rm.plan(routes)
+	if err != nil {
+		return backend.NewSyncResponse(), err
+	}
+	return p.response(), nil
+}
+
+func (rm *RouteManager) Sync(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	return rm.sync(routes)
+}
+
+// insert creates the route to subnet in table, or replaces the existing one
+// if it is owned.
+func (rm *RouteManager) insert(table, subnet, eni string) error {
+	err := rm.ec2.createRoute(table, subnet, eni)
+	if !isEC2Error(err, "RouteAlreadyExists") {
+		return err
+	}
+	tables, err := rm.ec2.describeRouteTables([]string{table})
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if r := findRoute(t, subnet); r != nil && !rm.owned(*r) {
+			return conflictError(table, subnet)
+		}
+	}
+	return rm.ec2.replaceRoute(table, subnet, eni)
+}
+
+// sync applies the plan for in. Routes that fail, e.g. because a route of
+// another owner has the same destination, are reported in the response
+// errors.
+func (rm *RouteManager) sync(in backend.RouteTable) (*backend.SyncResponse, error) {
+	response := backend.NewSyncResponse()
+	p, err := rm.plan(in)
+	if err != nil {
+		return response, err
+	}
+	response.Errors = append(response.Errors, p.errors...)
+	for _, t := range p.tables {
+		for _, subnet := range t.Delete {
+			if err := rm.ec2.deleteRoute(t.table, subnet); err != nil {
+				response.Errors = append(response.Errors, &backend.RouteError{Route: t.routeName(subnet), Err: err})
+				continue
+			}
+			response.Deleted = append(response.Deleted, t.routeName(subnet))
+		}
+		for _, subnet := range t.Replace {
+			if err := rm.ec2.replaceRoute(t.table, subnet, p.desired[subnet]); err != nil {
+				response.Errors = append(response.Errors, &backend.RouteError{Route: t.routeName(subnet), Err: err})
+				continue
+			}
+			response.Replaced = append(response.Replaced, t.routeName(subnet))
+		}
+		for _, subnet := range t.Insert {
+			if err := rm.insert(t.table, subnet, p.desired[subnet]); err != nil {
+				response.Errors = append(response.Errors, &backend.RouteError{Route: t.routeName(subnet), Err: err})
+				continue
+			}
+			response.Inserted = append(response.Inserted, t.routeName(subnet))
+		}
+		for _, subnet := range t.Unchanged {
+			response.Unchanged = append(response.Unchanged, t.routeName(subnet))
+		}
+	}
+	if len(response.Errors) > 0 {
+		return response, fmt.Errorf("aws: %d routes failed to sync", len(response.Errors))
+	}
+	return response, nil
+}
+
+// routePlan holds the diff between the owned routes of each route table
+// and a flannel route table resolved to network interfaces.
+type routePlan struct {
+	desired backend.RouteTable
+	tables  []tablePlan
+	// errors holds the routes whose next hop could not be resolved. They
+	// are left as they are.
+	errors []*backend.RouteError
+}
+
+// tablePlan is the diff for a single route table.
+type tablePlan struct {
+	*backend.Changes
+	table string
+}
+
+func (t tablePlan) routeName(subnet string) string {
+	return formatRouteName([]string{t.table}, subnet)
+}
+
+func (p *routePlan) response() *backend.SyncResponse {
+	response := backend.NewSyncResponse()
+	for _, t := range p.tables {
+		response.Merge(t.Response(t.routeName))
+	}
+	response.Errors = append(response.Errors, p.errors...)
+	return response
+}
+
+// plan compares the owned routes of every route table with in, after
+// resolving next hops to network interfaces, without changing anything.
+// Routes that are not active, e.g. because their target is gone, are
+// replaced. Subnets whose next hop has no network interface keep their
+// current routes and are reported as errors.
+func (rm *RouteManager) plan(in backend.RouteTable) (*routePlan, error) {
+	ips := make([]string, 0, len(in))
+	for _, ip := range in {
+		ips = append(ips, ip)
+	}
+	enis, err := rm.nextHops(ips)
+	if err != nil {
+		return nil, err
+	}
+	p := &routePlan{desired: make(backend.RouteTable)}
+	var unresolved []string
+	for subnet, ip := range in {
+		eni, ok := enis[ip]
+		if !ok {
+			unresolved = append(unresolved, subnet)
+			p.errors = append(p.errors, &backend.RouteError{Route: formatRouteName(rm.routeTables, subnet), Err: noNetworkInterfaceError(ip)})
+			continue
+		}
+		p.desired[subnet] = eni
+	}
+	tables, err := rm.ec2.describeRouteTables(rm.routeTables)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tables {
+		current := make(backend.RouteTable)
+		for _, r := range t.Routes {
+			if !rm.owned(r) {
+				continue
+			}
+			current[r.DestinationCidrBlock] = r.NetworkInterfaceID
+			if r.State != "active" {
+				current[r.DestinationCidrBlock] = ""
+			}
+		}
+		desired := make(backend.RouteTable)
+		for subnet, eni := range p.desired {
+			desired[subnet] = eni
+		}
+		for _, subnet := range unresolved {
+			if eni, ok := current[subnet]; ok {
+				desired[subnet] = eni
+			}
+		}
+		p.tables = append(p.tables, tablePlan{Changes: backend.Diff(desired, current), table: t.RouteTableID})
+	}
+	return p, nil
+}
+
+// nextHops resolves ips, each of which may be either a private or a public
+// address, to the network interfaces that own them. Addresses without a
+// network interface are left out. All private addresses are looked up in a
+// single request, and only those that do not match in a second one as
+// public addresses.
+func (rm *RouteManager) nextHops(ips []string) (map[string]string, error) {
+	enis := make(map[string]string)
+	for _, filter := range []string{"addresses.private-ip-address", "association.public-ip"} {
+		if len(ips) == 0 {
+			break
+		}
+		interfaces, err := rm.ec2.describeNetworkInterfaces(filter, ips)
+		if err != nil {
+			return nil, err
+		}
+		owners := make(map[string]string)
+		for _, ni := range interfaces {
+			for _, a := range ni.PrivateIPAddresses {
+				owners[a.PrivateIPAddress] = ni.NetworkInterfaceID
+				if a.PublicIP != "" {
+					owners[a.PublicIP] = ni.NetworkInterfaceID
+				}
+			}
+		}
+		var rest []string
+		for _, ip := range ips {
+			if eni, ok := owners[ip]; ok {
+				enis[ip] = eni
+			} else {
+				rest = append(rest, ip)
+			}
+		}
+		ips = rest
+	}
+	return enis, nil
+}
+
+func noNetworkInterfaceError(ip string) error {
+	return fmt.Errorf("aws: no network interface found for %s", ip)
+}
+
+func (rm *RouteManager) owned(r ec2Route) bool {
+	if r.Origin != "CreateRoute" || (r.InstanceID == "" && r.NetworkInterfaceID == "") {
+		return false
+	}
+	_, dest, err := net.ParseCIDR(r.DestinationCidrBlock)
+	if err != nil {
+		return false
+	}
+	ones, _ := dest.Mask.Size()
+	clusterOnes, _ := rm.clusterNet.Mask.Size()
+	return ones > clusterOnes && rm.clusterNet.Contains(dest.IP)
+}
+
+// findRoute returns the route to subnet in t, or nil.
+func findRoute(t ec2RouteTable, subnet string) *ec2Route {
+	for i, r := range t.Routes {
+		if r.DestinationCidrBlock == subnet {
+			return &t.Routes[i]
+		}
+	}
+	return nil
+}
+
+func conflictError(table, subnet string) error {
+	return fmt.Errorf("aws: route to %s in %s is not owned by the route manager", subnet, table)
+}
+
+func formatRouteName(tables []string, subnet string) string {
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t + ":" + subnet
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,405 @@
+package aws
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/backendtest"
+)
+
+// fakeEC2 is a stand-in for the parts of the EC2 API the backend uses. It
+// checks the signature of every request. Any address that is neither in
+// missing nor in public is a private address of the network interface
+// eni-<address>; the addresses in public map to a private address.
+type fakeEC2 struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	tables    map[string][]ec2Route
+	missing   map[string]bool
+	public    map[string]string
+	describes int
+}
+
+func newFakeEC2(t *testing.T, tables ...string) *fakeEC2 {
+	f := &fakeEC2{
+		t:       t,
+		tables:  make(map[string][]ec2Route),
+		missing: make(map[string]bool),
+		public:  make(map[string]string),
+	}
+	for _, table := range tables {
+		f.tables[table] = []ec2Route{
+			{DestinationCidrBlock: "10.0.0.0/16", GatewayID: "local", Origin: "CreateRouteTable", State: "active"},
+		}
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	return f
+}
+
+func (f *fakeEC2) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	if err := f.checkSignature(r, body); err != nil {
+		f.t.Error(err)
+		writeEC2Error(w, "AuthFailure", err.Error())
+		return
+	}
+	params, err := url.ParseQuery(string(body))
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	action := params.Get("Action")
+	table, dest, eni := params.Get("RouteTableId"), params.Get("DestinationCidrBlock"), params.Get("NetworkInterfaceId")
+	switch action {
+	case "DescribeRouteTables":
+		var resp describeRouteTablesResponse
+		for i := 1; params.Get(fmt.Sprintf("RouteTableId.%d", i)) != ""; i++ {
+			id := params.Get(fmt.Sprintf("RouteTableId.%d", i))
+			resp.RouteTables = append(resp.RouteTables, ec2RouteTable{RouteTableID: id, Routes: f.tables[id]})
+		}
+		writeXML(w, &resp)
+	case "DescribeNetworkInterfaces":
+		f.describes++
+		var resp describeNetworkInterfacesResponse
+		for i := 1; params.Get(fmt.Sprintf("Filter.1.Value.%d", i)) != ""; i++ {
+			ip, publicIP := params.Get(fmt.Sprintf("Filter.1.Value.%d", i)), ""
+			switch params.Get("Filter.1.Name") {
+			case "addresses.private-ip-address":
+				if f.missing[ip] || f.public[ip] != "" {
+					continue
+				}
+			case "association.public-ip":
+				if f.public[ip] == "" {
+					continue
+				}
+				ip, publicIP = f.public[ip], ip
+			}
+			resp.NetworkInterfaces = append(resp.NetworkInterfaces, ec2NetworkInterface{
+				NetworkInterfaceID: "eni-" + ip,
+				PrivateIPAddresses: []ec2PrivateIPAddress{{PrivateIPAddress: ip, PublicIP: publicIP}},
+			})
+		}
+		writeXML(w, &resp)
+	case "CreateRoute":
+		if f.find(table, dest) >= 0 {
+			writeEC2Error(w, "RouteAlreadyExists", "route exists")
+			return
+		}
+		f.tables[table] = append(f.tables[table], ec2Route{DestinationCidrBlock: dest, NetworkInterfaceID: eni, Origin: "CreateRoute", State: "active"})
+		writeXML(w, &returnResponse{Return: true})
+	case "ReplaceRoute":
+		i := f.find(table, dest)
+		if i < 0 {
+			writeEC2Error(w, "InvalidRoute.NotFound", "no route")
+			return
+		}
+		f.tables[table][i] = ec2Route{DestinationCidrBlock: dest, NetworkInterfaceID: eni, Origin: "CreateRoute", State: "active"}
+		writeXML(w, &returnResponse{Return: true})
+	case "DeleteRoute":
+		i := f.find(table, dest)
+		if i < 0 {
+			writeEC2Error(w, "InvalidRoute.NotFound", "no route")
+			return
+		}
+		f.tables[table] = append(f.tables[table][:i], f.tables[table][i+1:]...)
+		writeXML(w, &returnResponse{Return: true})
+	default:
+		writeEC2Error(w, "InvalidAction", action)
+	}
+}
+
+// checkSignature signs a copy of r with the credentials of the test and
+// compares the result.
+func (f *fakeEC2) checkSignature(r *http.Request, body []byte) error {
+	t, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return fmt.Errorf("bad X-Amz-Date: %v", err)
+	}
+	u := *r.URL
+	u.Host = r.Host
+	req, err := http.NewRequest(r.Method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	signV4(req, body, &credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, "test-region", "ec2", t)
+	if got, want := r.Header.Get("Authorization"), req.Header.Get("Authorization"); got != want {
+		return fmt.Errorf("Authorization is %q, want %q", got, want)
+	}
+	return nil
+}
+
+func (f *fakeEC2) find(table, dest string) int {
+	for i, r := range f.tables[table] {
+		if r.DestinationCidrBlock == dest {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fakeEC2) add(table string, r ec2Route) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tables[table] = append(f.tables[table], r)
+}
+
+// routes returns the routes of table as destination via target.
+func (f *fakeEC2) routes(table string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rs []string
+	for _, r := range f.tables[table] {
+		target := r.NetworkInterfaceID + r.InstanceID + r.GatewayID
+		rs = append(rs, r.DestinationCidrBlock+" via "+target)
+	}
+	sort.Strings(rs)
+	return rs
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeEC2Error(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+	xml.NewEncoder(w).Encode(&ec2ErrorResponse{Errors: []ec2Error{{Code: code, Message: message}}})
+}
+
+func newTestRouteManager(t *testing.T, f *fakeEC2, tables ...string) *RouteManager {
+	rm, err := New(&Config{
+		ClusterCIDR: "10.244.0.0/16",
+		Endpoint:    f.server.URL + "/",
+		Region:      "test-region",
+		RouteTables: tables,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+func TestDescribeRouteTables(t *testing.T) {
+	f := newFakeEC2(t, "rtb-1", "rtb-2")
+	f.add("rtb-2", ec2Route{DestinationCidrBlock: "10.244.1.0/24", InstanceID: "i-1", Origin: "CreateRoute", State: "blackhole"})
+	rm := newTestRouteManager(t, f, "rtb-1", "rtb-2")
+	tables, err := rm.ec2.describeRouteTables([]string{"rtb-1", "rtb-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 || tables[0].RouteTableID != "rtb-1" || tables[1].RouteTableID != "rtb-2" {
+		t.Fatalf("got route tables %+v", tables)
+	}
+	want := ec2Route{DestinationCidrBlock: "10.244.1.0/24", InstanceID: "i-1", Origin: "CreateRoute", State: "blackhole"}
+	if rs := tables[1].Routes; len(rs) != 2 || rs[1] != want {
+		t.Errorf("rtb-2 routes are %+v, want local route and %+v", rs, want)
+	}
+}
+
+func TestOwned(t *testing.T) {
+	f := newFakeEC2(t)
+	rm := newTestRouteManager(t, f, "rtb-1")
+	for _, tt := range []struct {
+		route ec2Route
+		owned bool
+	}{
+		{ec2Route{DestinationCidrBlock: "10.244.1.0/24", NetworkInterfaceID: "eni-1", Origin: "CreateRoute"}, true},
+		{ec2Route{DestinationCidrBlock: "10.244.1.0/24", InstanceID: "i-1", Origin: "CreateRoute"}, true},
+		{ec2Route{DestinationCidrBlock: "10.244.1.0/24", NetworkInterfaceID: "eni-1", Origin: "CreateRouteTable"}, false},
+		{ec2Route{DestinationCidrBlock: "10.244.1.0/24", NetworkInterfaceID: "eni-1", Origin: "EnableVgwRoutePropagation"}, false},
+		{ec2Route{DestinationCidrBlock: "10.244.1.0/24", GatewayID: "igw-1", Origin: "CreateRoute"}, false},
+		{ec2Route{DestinationCidrBlock: "10.244.0.0/16", NetworkInterfaceID: "eni-1", Origin: "CreateRoute"}, false},
+		{ec2Route{DestinationCidrBlock: "10.0.0.0/8", NetworkInterfaceID: "eni-1", Origin: "CreateRoute"}, false},
+		{ec2Route{DestinationCidrBlock: "192.168.1.0/24", NetworkInterfaceID: "eni-1", Origin: "CreateRoute"}, false},
+		{ec2Route{DestinationCidrBlock: "invalid", NetworkInterfaceID: "eni-1", Origin: "CreateRoute"}, false},
+	} {
+		if got := rm.owned(tt.route); got != tt.owned {
+			t.Errorf("owned(%+v) = %v, want %v", tt.route, got, tt.owned)
+		}
+	}
+}
+
+func TestSyncLeavesUnownedRoutes(t *testing.T) {
+	f := newFakeEC2(t, "rtb-1", "rtb-2")
+	f.add("rtb-1", ec2Route{DestinationCidrBlock: "10.244.9.0/24", GatewayID: "vgw-1", Origin: "CreateRoute", State: "active"})
+	f.add("rtb-1", ec2Route{DestinationCidrBlock: "10.244.8.0/24", NetworkInterfaceID: "eni-9", Origin: "CreateRoute", State: "active"})
+	f.add("rtb-2", ec2Route{DestinationCidrBlock: "10.244.1.0/24", NetworkInterfaceID: "eni-10.240.0.9", Origin: "CreateRoute", State: "blackhole"})
+	rm := newTestRouteManager(t, f, "rtb-1", "rtb-2")
+	resp, err := rm.Sync(map[string]string{"10.244.1.0/24": "10.240.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(resp.Deleted, ","); got != "rtb-1:10.244.8.0/24" {
+		t.Errorf("deleted %s, want rtb-1:10.244.8.0/24", got)
+	}
+	if got := strings.Join(resp.Inserted, ","); got != "rtb-1:10.244.1.0/24" {
+		t.Errorf("inserted %s, want rtb-1:10.244.1.0/24", got)
+	}
+	if got := strings.Join(resp.Replaced, ","); got != "rtb-2:10.244.1.0/24" {
+		t.Errorf("replaced %s, want rtb-2:10.244.1.0/24", got)
+	}
+	for table, want := range map[string]string{
+		"rtb-1": "10.0.0.0/16 via local,10.244.1.0/24 via eni-10.240.0.2,10.244.9.0/24 via vgw-1",
+		"rtb-2": "10.0.0.0/16 via local,10.244.1.0/24 via eni-10.240.0.2",
+	} {
+		if got := strings.Join(f.routes(table), ","); got != want {
+			t.Errorf("%s routes are %s, want %s", table, got, want)
+		}
+	}
+}
+
+func TestNextHops(t *testing.T) {
+	f := newFakeEC2(t, "rtb-1")
+	f.missing["10.240.0.9"] = true
+	f.public["203.0.113.3"] = "10.240.0.3"
+	rm := newTestRouteManager(t, f, "rtb-1")
+	enis, err := rm.nextHops([]string{"10.240.0.2", "203.0.113.3", "10.240.0.9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"10.240.0.2": "eni-10.240.0.2", "203.0.113.3": "eni-10.240.0.3"}
+	if fmt.Sprint(enis) != fmt.Sprint(want) {
+		t.Errorf("got next hops %v, want %v", enis, want)
+	}
+	if f.describes != 2 {
+		t.Errorf("described network interfaces %d times, want 2", f.describes)
+	}
+
+	f.describes = 0
+	if _, err := rm.nextHops([]string{"10.240.0.2", "10.240.0.3"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.describes != 1 {
+		t.Errorf("described network interfaces %d times for private addresses, want 1", f.describes)
+	}
+}
+
+func TestSyncKeepsUnresolvedRoutes(t *testing.T) {
+	f := newFakeEC2(t, "rtb-1")
+	f.missing["10.240.0.8"] = true
+	f.missing["10.240.0.9"] = true
+	f.add("rtb-1", ec2Route{DestinationCidrBlock: "10.244.2.0/24", NetworkInterfaceID: "eni-10.240.0.3", Origin: "CreateRoute", State: "active"})
+	rm := newTestRouteManager(t, f, "rtb-1")
+	resp, err := rm.Sync(map[string]string{
+		"10.244.1.0/24": "10.240.0.2",
+		"10.244.2.0/24": "10.240.0.9",
+		"10.244.3.0/24": "10.240.0.8",
+	})
+	if err == nil {
+		t.Error("expected an error for the unresolved next hops")
+	}
+	var failed []string
+	for _, e := range resp.Errors {
+		failed = append(failed, e.Route)
+	}
+	sort.Strings(failed)
+	if got := strings.Join(failed, ","); got != "rtb-1:10.244.2.0/24,rtb-1:10.244.3.0/24" {
+		t.Errorf("failed %s, want rtb-1:10.244.2.0/24,rtb-1:10.244.3.0/24", got)
+	}
+	if got := strings.Join(resp.Inserted, ","); got != "rtb-1:10.244.1.0/24" {
+		t.Errorf("inserted %s, want rtb-1:10.244.1.0/24", got)
+	}
+	if got := strings.Join(resp.Unchanged, ","); got != "rtb-1:10.244.2.0/24" {
+		t.Errorf("unchanged %s, want rtb-1:10.244.2.0/24", got)
+	}
+	want := "10.0.0.0/16 via local,10.244.1.0/24 via eni-10.240.0.2,10.244.2.0/24 via eni-10.240.0.3"
+	if got := strings.Join(f.routes("rtb-1"), ","); got != want {
+		t.Errorf("routes are %s, want %s", got, want)
+	}
+	if f.describes != 2 {
+		t.Errorf("described network interfaces %d times, want 2", f.describes)
+	}
+}
+
+func TestForeignRoutes(t *testing.T) {
+	f := newFakeEC2(t, "rtb-1", "rtb-2")
+	f.add("rtb-1", ec2Route{DestinationCidrBlock: "10.244.9.0/24", GatewayID: "vgw-1", Origin: "CreateRoute", State: "active"})
+	f.add("rtb-2", ec2Route{DestinationCidrBlock: "10.244.9.0/24", NetworkInterfaceID: "eni-10.240.0.9", Origin: "CreateRoute", State: "active"})
+	rm := newTestRouteManager(t, f, "rtb-1", "rtb-2")
+	want := "aws: route to 10.244.9.0/24 in rtb-1 is not owned by the route manager"
+	if _, err := rm.Insert("10.240.0.2", "10.244.9.0/24"); err == nil || err.Error() != want {
+		t.Errorf("Insert over a foreign route returned %v, want %s", err, want)
+	}
+	if got := strings.Join(f.routes("rtb-2"), ","); got != "10.0.0.0/16 via local,10.244.9.0/24 via eni-10.240.0.2" {
+		t.Errorf("rtb-2 routes are %s, want the owned route replaced", got)
+	}
+	if _, err := rm.Delete("10.244.9.0/24"); err == nil || err.Error() != want {
+		t.Errorf("Delete of a foreign route returned %v, want %s", err, want)
+	}
+	if got := strings.Join(f.routes("rtb-2"), ","); got != "10.0.0.0/16 via local" {
+		t.Errorf("rtb-2 routes are %s, want the owned route deleted", got)
+	}
+
+	resp, err := rm.Sync(map[string]string{"10.244.1.0/24": "10.240.0.2", "10.244.9.0/24": "10.240.0.2"})
+	if err == nil {
+		t.Error("expected an error for the foreign route")
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Error() != "rtb-1:10.244.9.0/24: "+want {
+		t.Errorf("got errors %v, want %s", resp.Errors, want)
+	}
+	if got := strings.Join(resp.Inserted, ","); got != "rtb-1:10.244.1.0/24,rtb-2:10.244.1.0/24,rtb-2:10.244.9.0/24" {
+		t.Errorf("inserted %s", got)
+	}
+	if got := strings.Join(f.routes("rtb-1"), ","); got != "10.0.0.0/16 via local,10.244.1.0/24 via eni-10.240.0.2,10.244.9.0/24 via vgw-1" {
+		t.Errorf("rtb-1 routes are %s, want the foreign route kept", got)
+	}
+}
+
+func TestEC2Error(t *testing.T) {
+	f := newFakeEC2(t, "rtb-1")
+	rm := newTestRouteManager(t, f, "rtb-1")
+	if err := rm.ec2.replaceRoute("rtb-1", "10.244.1.0/24", "eni-1"); !isEC2Error(err, "InvalidRoute.NotFound") {
+		t.Errorf("replaceRoute of a missing route returned %v, want InvalidRoute.NotFound", err)
+	}
+	if err := rm.ec2.deleteRoute("rtb-1", "10.244.1.0/24"); err != nil {
+		t.Errorf("deleteRoute of a missing route returned %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	var f *fakeEC2
+	backendtest.Run(t, &backendtest.Harness{
+		New: func(t *testing.T) backend.RouteManager {
+			f = newFakeEC2(t, "rtb-1")
+			return newTestRouteManager(t, f, "rtb-1")
+		},
+		Routes: func(t *testing.T) map[string]string {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			m := make(map[string]string)
+			for _, r := range f.tables["rtb-1"] {
+				if r.GatewayID != "local" {
+					m[r.DestinationCidrBlock] = strings.TrimPrefix(r.NetworkInterfaceID, "eni-")
+				}
+			}
+			return m
+		},
+		AddForeignRoute: func(t *testing.T, ip, subnet string) {
+			f.add("rtb-1", ec2Route{DestinationCidrBlock: subnet, NetworkInterfaceID: "eni-" + ip, Origin: "CreateRoute", State: "active"})
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package aws
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignV4 checks the get-vanilla example of the AWS Signature Version 4
+// test suite.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := &credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization is %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date is %q", got)
+	}
+}
+
+func TestSignV4SecurityToken(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://ec2.us-east-1.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := &credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", Token: "token"}
+	signV4(req, []byte("Action=DescribeRouteTables"), creds, "us-east-1", "ec2", time.Now().UTC())
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("X-Amz-Security-Token is %q, want token", got)
+	}
+	auth := req.Header.Get("Authorization")
+	if want := "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,"; !strings.Contains(auth, want) {
+		t.Errorf("Authorization %q lacks %q", auth, want)
+	}
+}
@@ -0,0 +1,216 @@
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const networkAPIVersion = "2018-08-01"
+
+var (
+	// maxRetries and retryBackoff control the retries of throttled
+	// requests and of requests that collide with another operation on the
+	// route table. The backoff doubles with every retry unless the response
+	// says how long to wait.
+	maxRetries   = 5
+	retryBackoff = time.Second
+	// asyncPollInterval and asyncTimeout control the polling of
+	// asynchronous operations.
+	asyncPollInterval = 2 * time.Second
+	asyncTimeout      = 5 * time.Minute
+)
+
+type armClient struct {
+	client     *http.Client
+	endpoint   string
+	routeTable string
+	tokens     *tokenSource
+}
+
+type armError struct {
+	Err    armErrorDetail `json:"error"`
+	status int
+}
+
+type armErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *armError) Error() string {
+	if e.Err.Code == "" {
+		return fmt.Sprintf("azure: unexpected status %d", e.status)
+	}
+	return fmt.Sprintf("azure: %s: %s", e.Err.Code, e.Err.Message)
+}
+
+// retryable reports whether the request may succeed when sent again.
+func (e *armError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.Err.Code == "AnotherOperationInProgress"
+}
+
+// asyncOperation is the status of an asynchronous operation, as returned by
+// the URL in the Azure-AsyncOperation header.
+type asyncOperation struct {
+	Status string         `json:"status"`
+	Err    armErrorDetail `json:"error"`
+}
+
+type route struct {
+	Name       string          `json:"name,omitempty"`
+	Properties routeProperties `json:"properties"`
+}
+
+type routeProperties struct {
+	AddressPrefix    string `json:"addressPrefix"`
+	NextHopType      string `json:"nextHopType"`
+	NextHopIPAddress string `json:"nextHopIpAddress,omitempty"`
+}
+
+type routeList struct {
+	Value    []*route `json:"value"`
+	NextLink string   `json:"nextLink"`
+}
+
+func (c *armClient) listRoutes() ([]*route, error) {
+	rs := make([]*route, 0)
+	url := c.routesURL("") + "?api-version=" + networkAPIVersion
+	for url != "" {
+		var list routeList
+		if err := c.do("GET", url, nil, &list); err != nil {
+			return nil, err
+		}
+		rs = append(rs, list.Value...)
+		url = list.NextLink
+	}
+	return rs, nil
+}
+
+func (c *armClient) putRoute(r *route) error {
+	return c.do("PUT", c.routesURL(r.Name)+"?api-version="+networkAPIVersion, r, nil)
+}
+
+func (c *armClient) deleteRoute(name string) error {
+	return c.do("DELETE", c.routesURL(name)+"?api-version="+networkAPIVersion, nil, nil)
+}
+
+func (c *armClient) routesURL(name string) string {
+	u := c.endpoint + c.routeTable + "/routes"
+	if name != "" {
+		u += "/" + name
+	}
+	return u
+}
+
+// do sends a request and decodes the response into out. Asynchronous
+// operations are waited for. Throttled requests and those that collide with
+// another operation on the route table are retried.
+func (c *armClient) do(method, url string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	backoff := retryBackoff
+	for retries := 0; ; retries++ {
+		resp, data, err := c.send(method, url, body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			e := &armError{status: resp.StatusCode}
+			json.Unmarshal(data, e)
+			err = e
+		} else if op := resp.Header.Get("Azure-AsyncOperation"); op != "" {
+			err = c.wait(op, retryAfter(resp, asyncPollInterval))
+		}
+		if e, ok := err.(*armError); ok && e.retryable() && retries < maxRetries {
+			time.Sleep(retryAfter(resp, backoff))
+			backoff *= 2
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if out != nil {
+			return json.Unmarshal(data, out)
+		}
+		return nil
+	}
+}
+
+// wait polls the asynchronous operation at url every interval until it
+// finishes. Failed operations are returned as an *armError.
+func (c *armClient) wait(url string, interval time.Duration) error {
+	deadline := time.Now().Add(asyncTimeout)
+	for {
+		time.Sleep(interval)
+		resp, data, err := c.send("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			e := &armError{status: resp.StatusCode}
+			json.Unmarshal(data, e)
+			return e
+		}
+		var op asyncOperation
+		if err := json.Unmarshal(data, &op); err != nil {
+			return err
+		}
+		switch op.Status {
+		case "Succeeded":
+			return nil
+		case "Failed", "Canceled":
+			e := &armError{Err: op.Err, status: resp.StatusCode}
+			if e.Err.Code == "" {
+				e.Err.Code, e.Err.Message = op.Status, "asynchronous operation did not succeed"
+			}
+			return e
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("azure: asynchronous operation %s did not finish within %v", url, asyncTimeout)
+		}
+		interval = retryAfter(resp, interval)
+	}
+}
+
+func (c *armClient) send(method, url string, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token, err := c.tokens.get()
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, data, nil
+}
+
+// retryAfter returns the wait the Retry-After header of resp asks for, or d.
+func retryAfter(resp *http.Response, d time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return d
+}
@@ -0,0 +1,113 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type computeMetadata struct {
+	ResourceGroupName string `json:"resourceGroupName"`
+	SubscriptionID    string `json:"subscriptionId"`
+}
+
+type token struct {
+	AccessToken string `json:"access_token"`
+	ExpiresOn   string `json:"expires_on"`
+	expires     time.Time
+}
+
+// tokenSource hands out ARM access tokens, using the service principal
+// client credentials when set and the instance managed identity otherwise.
+type tokenSource struct {
+	adEndpoint   string
+	clientID     string
+	clientSecret string
+	resource     string
+	tenantID     string
+
+	mu    sync.Mutex
+	token *token
+}
+
+func (ts *tokenSource) get() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != nil && time.Now().Add(5*time.Minute).Before(ts.token.expires) {
+		return ts.token.AccessToken, nil
+	}
+	var req *http.Request
+	var err error
+	if ts.clientID != "" {
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", ts.clientID)
+		form.Set("client_secret", ts.clientSecret)
+		form.Set("resource", ts.resource)
+		req, err = http.NewRequest("POST", ts.adEndpoint+"/"+ts.tenantID+"/oauth2/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = metadataRequest("/identity/oauth2/token?api-version=2018-02-01&resource=" + url.QueryEscape(ts.resource))
+		if err != nil {
+			return "", err
+		}
+	}
+	var t token
+	if err := doJSON(req, &t); err != nil {
+		return "", err
+	}
+	expiresOn, err := strconv.ParseInt(t.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("azure: invalid token expiry %q", t.ExpiresOn)
+	}
+	t.expires = time.Unix(expiresOn, 0)
+	ts.token = &t
+	return t.AccessToken, nil
+}
+
+func computeFromMetadata() (*computeMetadata, error) {
+	req, err := metadataRequest("/instance/compute?api-version=2017-08-01")
+	if err != nil {
+		return nil, err
+	}
+	var m computeMetadata
+	if err := doJSON(req, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func metadataRequest(path string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", metadataEndpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Metadata", "true")
+	return req, nil
+}
+
+func doJSON(req *http.Request, v interface{}) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("azure: %s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return json.Unmarshal(data, v)
+}
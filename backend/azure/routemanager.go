@@ -0,0 +1,254 @@
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+var metadataEndpoint = "http://169.254.169.254/metadata"
+
+var replacer = strings.NewReplacer(".", "-", "/", "-")
+
+const (
+	DefaultEndpoint   = "https://management.azure.com"
+	DefaultADEndpoint = "https://login.microsoftonline.com"
+)
+
+type Config struct {
+	// ADEndpoint and Endpoint override the Active Directory and Resource
+	// Manager endpoints, e.g. for a sovereign cloud or a local fake. Tokens
+	// are requested for the Resource Manager endpoint.
+	ADEndpoint string
+	Endpoint   string
+	// ClientID, ClientSecret and TenantID select a service principal.
+	// Without them the managed identity of the instance is used.
+	ClientID     string
+	ClientSecret string
+	TenantID     string
+	// ResourceGroup and SubscriptionID default to those of the instance.
+	ResourceGroup  string
+	RouteTable     string
+	SubscriptionID string
+}
+
+type RouteManager struct {
+	arm        *armClient
+	routeTable string
+}
+
+func New(config *Config) (*RouteManager, error) {
+	if config.RouteTable == "" {
+		return nil, errors.New("azure: route table is required")
+	}
+	subscription, resourceGroup := config.SubscriptionID, config.ResourceGroup
+	if subscription == "" || resourceGroup == "" {
+		m, err := computeFromMetadata()
+		if err != nil {
+			return nil, err
+		}
+		if subscription == "" {
+			subscription = m.SubscriptionID
+		}
+		if resourceGroup == "" {
+			resourceGroup = m.ResourceGroupName
+		}
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	adEndpoint := config.ADEndpoint
+	if adEndpoint == "" {
+		adEndpoint = DefaultADEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	rm := &RouteManager{
+		arm: &armClient{
+			client: &http.Client{Timeout: 60 * time.Second},
+			endpoint: fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/routeTables/",
+				endpoint, subscription, resourceGroup),
+			routeTable: config.RouteTable,
+			tokens: &tokenSource{
+				adEndpoint:   strings.TrimSuffix(adEndpoint, "/"),
+				clientID:     config.ClientID,
+				clientSecret: config.ClientSecret,
+				resource:     endpoint + "/",
+				tenantID:     config.TenantID,
+			},
+		},
+		routeTable: config.RouteTable,
+	}
+	return rm, nil
+}
+
+func (rm RouteManager) Delete(subnet string) (string, error) {
+	name := rm.routeName(subnet)
+	err := rm.delete(name)
+	return name, err
+}
+
+func (rm RouteManager) DeleteAllRoutes() ([]string, error) {
+	deleted := []string{}
+	var lastError error
+	rs, err := rm.routes()
+	if err != nil {
+		return deleted, err
+	}
+	for _, r := range rs {
+		if err := rm.delete(r.Name); err != nil {
+			lastError = err
+		}
+		deleted = append(deleted, r.Name)
+	}
+	return deleted, lastError
+}
+
+func (rm RouteManager) Insert(ip, subnet string) (string, error) {
+	name := rm.routeName(subnet)
+	return name, rm.insert(ip, subnet, name)
+}
+
+func (rm RouteManager) Plan(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	p, err := rm.plan(routes)
+	if err != nil {
+		return backend.NewSyncResponse(), err
+	}
+	return p.response(rm.routeName), nil
+}
+
+func (rm RouteManager) Sync(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	return rm.sync(routes)
+}
+
+func (rm RouteManager) delete(name string) error {
+	return rm.arm.deleteRoute(name)
+}
+
+func (rm RouteManager) insert(ip, subnet, name string) error {
+	r := rm.newRoute(ip, subnet)
+	r.Name = name
+	return rm.arm.putRoute(r)
+}
+
+func (rm RouteManager) sync(in backend.RouteTable) (*backend.SyncResponse, error) {
+	response := backend.NewSyncResponse()
+	p, err := rm.plan(in)
+	if err != nil {
+		return response, err
+	}
+	for _, r := range p.stray {
+		if err := rm.delete(r.Name); err != nil {
+			return response, err
+		}
+		response.Deleted = append(response.Deleted, r.Name)
+	}
+	for _, subnet := range p.Delete {
+		name := rm.routeName(subnet)
+		if err := rm.delete(name); err != nil {
+			return response, err
+		}
+		response.Deleted = append(response.Deleted, name)
+	}
+	// Routes are updated in place by writing them again.
+	for _, subnet := range p.Replace {
+		name := rm.routeName(subnet)
+		if err := rm.insert(in[subnet], subnet, name); err != nil {
+			return response, err
+		}
+		response.Replaced = append(response.Replaced, name)
+	}
+	for _, subnet := range p.Insert {
+		name := rm.routeName(subnet)
+		if err := rm.insert(in[subnet], subnet, name); err != nil {
+			return response, err
+		}
+		response.Inserted = append(response.Inserted, name)
+	}
+	for _, subnet := range p.Unchanged {
+		response.Unchanged = append(response.Unchanged, rm.routeName(subnet))
+	}
+	return response, nil
+}
+
+// routePlan is the diff between the owned routes and a flannel route table,
+// keyed by destination subnet.
+type routePlan struct {
+	*backend.Changes
+	// stray holds the owned routes not named after their destination.
+	stray []*route
+}
+
+func (p *routePlan) response(name func(subnet string) string) *backend.SyncResponse {
+	response := backend.NewSyncResponse()
+	for _, r := range p.stray {
+		response.Deleted = append(response.Deleted, r.Name)
+	}
+	response.Merge(p.Response(name))
+	return response
+}
+
+// plan compares the owned routes with in, keyed by destination subnet,
+// without changing anything. Owned routes not named after their destination
+// are always deleted.
+func (rm RouteManager) plan(in backend.RouteTable) (*routePlan, error) {
+	p := &routePlan{}
+	rs, err := rm.routes()
+	if err != nil {
+		return nil, err
+	}
+	currentTable := make(backend.RouteTable)
+	for _, r := range rs {
+		if r.Name != rm.routeName(r.Properties.AddressPrefix) {
+			p.stray = append(p.stray, r)
+			continue
+		}
+		if r.Properties.NextHopType == "VirtualAppliance" {
+			currentTable[r.Properties.AddressPrefix] = r.Properties.NextHopIPAddress
+		} else {
+			currentTable[r.Properties.AddressPrefix] = ""
+		}
+	}
+	p.Changes = backend.Diff(in, currentTable)
+	return p, nil
+}
+
+func (rm RouteManager) newRoute(ip, subnet string) *route {
+	return &route{
+		Name: rm.routeName(subnet),
+		Properties: routeProperties{
+			AddressPrefix:    subnet,
+			NextHopType:      "VirtualAppliance",
+			NextHopIPAddress: ip,
+		},
+	}
+}
+
+// routes returns the routes in the route table owned by the route manager,
+// i.e. those carrying the flannel-<route table>- name prefix.
+func (rm RouteManager) routes() ([]*route, error) {
+	rs := make([]*route, 0)
+	all, err := rm.arm.listRoutes()
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("flannel-%s-", rm.routeTable)
+	for _, r := range all {
+		if strings.HasPrefix(r.Name, prefix) {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func (rm RouteManager) routeName(subnet string) string {
+	return formatRouteName(rm.routeTable, subnet)
+}
+
+func formatRouteName(routeTable, subnet string) string {
+	return fmt.Sprintf("flannel-%s-%s", routeTable, replacer.Replace(subnet))
+}
@@ -0,0 +1,402 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/backendtest"
+)
+
+const routesPath = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/routeTables/rt/routes"
+
+// fakeAzure is a stand-in for the Active Directory token endpoint, the
+// instance metadata service and the Resource Manager routes of the route
+// table rt. Route lists are paged pageSize routes at a time.
+//
+// With async set, route changes are asynchronous operations that are
+// InProgress for the first pending polls and then finish with failure as
+// their error code, or succeed if it is empty. The next throttled and busy
+// route changes are rejected with 429 and AnotherOperationInProgress.
+type fakeAzure struct {
+	t        *testing.T
+	server   *httptest.Server
+	pageSize int
+
+	mu       sync.Mutex
+	routes   map[string]*route
+	tokens   int
+	resource string
+
+	async      bool
+	pending    int
+	failure    string
+	operations map[string]*fakeOperation
+	polls      int
+	throttled  int
+	busy       int
+}
+
+// fakeOperation is an asynchronous operation that applies its change when
+// it succeeds.
+type fakeOperation struct {
+	polls int
+	apply func()
+}
+
+func newFakeAzure(t *testing.T) *fakeAzure {
+	f := &fakeAzure{t: t, pageSize: 2, routes: make(map[string]*route), operations: make(map[string]*fakeOperation)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	old := metadataEndpoint
+	metadataEndpoint = f.server.URL + "/metadata"
+	t.Cleanup(func() { metadataEndpoint = old })
+	backoff, interval := retryBackoff, asyncPollInterval
+	retryBackoff, asyncPollInterval = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { retryBackoff, asyncPollInterval = backoff, interval })
+	return f
+}
+
+func (f *fakeAzure) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/tenant/oauth2/token":
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("client_secret") != "secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		f.issueToken(w, r.PostForm.Get("resource"))
+	case r.URL.Path == "/metadata/identity/oauth2/token":
+		if r.Header.Get("Metadata") != "true" {
+			http.Error(w, "missing Metadata header", http.StatusBadRequest)
+			return
+		}
+		f.issueToken(w, r.URL.Query().Get("resource"))
+	case r.URL.Path == "/metadata/instance/compute":
+		json.NewEncoder(w).Encode(&computeMetadata{ResourceGroupName: "rg", SubscriptionID: "sub"})
+	case strings.HasPrefix(r.URL.Path, "/operations/"):
+		f.serveOperation(w, strings.TrimPrefix(r.URL.Path, "/operations/"))
+	case strings.HasPrefix(r.URL.Path, routesPath):
+		if r.Header.Get("Authorization") != "Bearer token-"+strconv.Itoa(f.tokens) {
+			writeARMError(w, http.StatusUnauthorized, "AuthenticationFailed", "bad token")
+			return
+		}
+		if r.URL.Query().Get("api-version") != networkAPIVersion {
+			writeARMError(w, http.StatusBadRequest, "InvalidApiVersion", r.URL.Query().Get("api-version"))
+			return
+		}
+		f.serveRoutes(w, r, strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, routesPath), "/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeAzure) issueToken(w http.ResponseWriter, resource string) {
+	f.tokens++
+	f.resource = resource
+	json.NewEncoder(w).Encode(&token{
+		AccessToken: "token-" + strconv.Itoa(f.tokens),
+		ExpiresOn:   strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+	})
+}
+
+func (f *fakeAzure) serveRoutes(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method == "PUT" || r.Method == "DELETE" {
+		switch {
+		case f.throttled > 0:
+			f.throttled--
+			writeARMError(w, http.StatusTooManyRequests, "TooManyRequests", "throttled")
+			return
+		case f.busy > 0:
+			f.busy--
+			writeARMError(w, http.StatusConflict, "AnotherOperationInProgress", "route table busy")
+			return
+		}
+	}
+	switch {
+	case r.Method == "GET" && name == "":
+		var names []string
+		for name := range f.routes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+		list := routeList{Value: []*route{}}
+		for i := skip; i < len(names) && i < skip+f.pageSize; i++ {
+			list.Value = append(list.Value, f.routes[names[i]])
+		}
+		if skip+f.pageSize < len(names) {
+			list.NextLink = fmt.Sprintf("%s%s?api-version=%s&skip=%d", f.server.URL, routesPath, networkAPIVersion, skip+f.pageSize)
+		}
+		json.NewEncoder(w).Encode(&list)
+	case r.Method == "PUT" && name != "":
+		var rt route
+		if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+			writeARMError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+			return
+		}
+		rt.Name = name
+		if f.async {
+			f.startOperation(w, http.StatusCreated, func() { f.routes[name] = &rt })
+			return
+		}
+		f.routes[name] = &rt
+		json.NewEncoder(w).Encode(&rt)
+	case r.Method == "DELETE" && name != "":
+		if _, ok := f.routes[name]; !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if f.async {
+			f.startOperation(w, http.StatusAccepted, func() { delete(f.routes, name) })
+			return
+		}
+		delete(f.routes, name)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeARMError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (f *fakeAzure) startOperation(w http.ResponseWriter, status int, apply func()) {
+	id := strconv.Itoa(len(f.operations) + 1)
+	f.operations[id] = &fakeOperation{polls: f.pending, apply: apply}
+	w.Header().Set("Azure-AsyncOperation", f.server.URL+"/operations/"+id)
+	w.WriteHeader(status)
+}
+
+func (f *fakeAzure) serveOperation(w http.ResponseWriter, id string) {
+	op, ok := f.operations[id]
+	if !ok {
+		writeARMError(w, http.StatusNotFound, "NotFound", id)
+		return
+	}
+	f.polls++
+	var status asyncOperation
+	switch {
+	case op.polls > 0:
+		op.polls--
+		status.Status = "InProgress"
+	case f.failure != "":
+		status.Status = "Failed"
+		status.Err = armErrorDetail{Code: f.failure, Message: "operation failed"}
+	default:
+		status.Status = "Succeeded"
+		if op.apply != nil {
+			op.apply()
+			op.apply = nil
+		}
+	}
+	json.NewEncoder(w).Encode(&status)
+}
+
+func writeARMError(w http.ResponseWriter, status int, code, message string) {
+	e := &armError{}
+	e.Err.Code, e.Err.Message = code, message
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+func (f *fakeAzure) add(name, prefix, nextHopType, nextHop string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[name] = &route{Name: name, Properties: routeProperties{
+		AddressPrefix:    prefix,
+		NextHopType:      nextHopType,
+		NextHopIPAddress: nextHop,
+	}}
+}
+
+// table returns the routes of the route table as name=prefix via next hop.
+func (f *fakeAzure) table() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rs []string
+	for name, r := range f.routes {
+		rs = append(rs, fmt.Sprintf("%s=%s via %s", name, r.Properties.AddressPrefix, r.Properties.NextHopIPAddress))
+	}
+	sort.Strings(rs)
+	return rs
+}
+
+func newTestRouteManager(t *testing.T, f *fakeAzure, config *Config) *RouteManager {
+	config.ADEndpoint = f.server.URL
+	config.Endpoint = f.server.URL + "/"
+	config.RouteTable = "rt"
+	rm, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+func TestTokenResource(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config Config
+	}{
+		{"service principal", Config{ClientID: "id", ClientSecret: "secret", TenantID: "tenant", ResourceGroup: "rg", SubscriptionID: "sub"}},
+		{"managed identity", Config{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAzure(t)
+			rm := newTestRouteManager(t, f, &tt.config)
+			if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err != nil {
+				t.Fatal(err)
+			}
+			if want := f.server.URL + "/"; f.resource != want {
+				t.Errorf("token requested for %q, want %q", f.resource, want)
+			}
+			if _, err := rm.Delete("10.244.1.0/24"); err != nil {
+				t.Fatal(err)
+			}
+			if f.tokens != 1 {
+				t.Errorf("requested %d tokens, want the first one reused", f.tokens)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	f := newFakeAzure(t)
+	f.add("default", "0.0.0.0/0", "Internet", "")
+	f.add("flannel-rt-10-244-1-0-24", "10.244.1.0/24", "VirtualAppliance", "10.240.0.9")
+	f.add("flannel-rt-10-244-2-0-24", "10.244.2.0/24", "VirtualAppliance", "10.240.0.3")
+	f.add("flannel-rt-10-244-3-0-24", "10.244.3.0/24", "VirtualAppliance", "10.240.0.4")
+	f.add("flannel-rt-renamed", "10.244.4.0/24", "VirtualAppliance", "10.240.0.5")
+	rm := newTestRouteManager(t, f, &Config{ResourceGroup: "rg", SubscriptionID: "sub"})
+	in := map[string]string{
+		"10.244.1.0/24": "10.240.0.2",
+		"10.244.2.0/24": "10.240.0.3",
+		"10.244.5.0/24": "10.240.0.6",
+	}
+	plan, err := rm.Plan(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rm.Sync(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(plan) != fmt.Sprint(resp) {
+		t.Errorf("Plan returned %v, Sync %v", plan, resp)
+	}
+	for _, r := range []struct {
+		name      string
+		got, want []string
+	}{
+		{"deleted", resp.Deleted, []string{"flannel-rt-10-244-3-0-24", "flannel-rt-renamed"}},
+		{"inserted", resp.Inserted, []string{"flannel-rt-10-244-5-0-24"}},
+		{"replaced", resp.Replaced, []string{"flannel-rt-10-244-1-0-24"}},
+		{"unchanged", resp.Unchanged, []string{"flannel-rt-10-244-2-0-24"}},
+	} {
+		sort.Strings(r.got)
+		if strings.Join(r.got, ",") != strings.Join(r.want, ",") {
+			t.Errorf("%s %v, want %v", r.name, r.got, r.want)
+		}
+	}
+	want := []string{
+		"default=0.0.0.0/0 via ",
+		"flannel-rt-10-244-1-0-24=10.244.1.0/24 via 10.240.0.2",
+		"flannel-rt-10-244-2-0-24=10.244.2.0/24 via 10.240.0.3",
+		"flannel-rt-10-244-5-0-24=10.244.5.0/24 via 10.240.0.6",
+	}
+	if got := f.table(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("route table is\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestARMError(t *testing.T) {
+	f := newFakeAzure(t)
+	rm := newTestRouteManager(t, f, &Config{ResourceGroup: "rg", SubscriptionID: "sub"})
+	err := rm.arm.do("POST", rm.arm.routesURL("x")+"?api-version="+networkAPIVersion, nil, nil)
+	if e, ok := err.(*armError); !ok || e.Err.Code != "MethodNotAllowed" || e.status != http.StatusMethodNotAllowed {
+		t.Errorf("got %v, want MethodNotAllowed", err)
+	}
+}
+
+func TestAsyncOperation(t *testing.T) {
+	f := newFakeAzure(t)
+	f.async, f.pending = true, 2
+	rm := newTestRouteManager(t, f, &Config{ResourceGroup: "rg", SubscriptionID: "sub"})
+	resp, err := rm.Sync(map[string]string{"10.244.1.0/24": "10.240.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(resp.Inserted, ","); got != "flannel-rt-10-244-1-0-24" {
+		t.Errorf("inserted %s, want flannel-rt-10-244-1-0-24", got)
+	}
+	if got := strings.Join(f.table(), ","); got != "flannel-rt-10-244-1-0-24=10.244.1.0/24 via 10.240.0.2" {
+		t.Errorf("route table is %s after the operation finished", got)
+	}
+	if f.polls != 3 {
+		t.Errorf("polled the operation %d times, want 3", f.polls)
+	}
+	if _, err := rm.Delete("10.244.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.table(); len(got) != 0 {
+		t.Errorf("route table is %v after the delete finished", got)
+	}
+
+	f.failure = "InternalServerError"
+	_, err = rm.Insert("10.240.0.3", "10.244.2.0/24")
+	if e, ok := err.(*armError); !ok || e.Err.Code != "InternalServerError" {
+		t.Errorf("Insert with a failing operation returned %v, want InternalServerError", err)
+	}
+	if got := f.table(); len(got) != 0 {
+		t.Errorf("route table is %v after the operation failed", got)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	f := newFakeAzure(t)
+	f.throttled, f.busy = 1, 2
+	rm := newTestRouteManager(t, f, &Config{ResourceGroup: "rg", SubscriptionID: "sub"})
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(f.table(), ","); got != "flannel-rt-10-244-1-0-24=10.244.1.0/24 via 10.240.0.2" {
+		t.Errorf("route table is %s after retrying", got)
+	}
+
+	f.busy = maxRetries + 1
+	_, err := rm.Delete("10.244.1.0/24")
+	if e, ok := err.(*armError); !ok || e.Err.Code != "AnotherOperationInProgress" {
+		t.Errorf("Delete of a busy route table returned %v, want AnotherOperationInProgress", err)
+	}
+	if f.busy != 0 {
+		t.Errorf("%d requests left unsent, want %d retries", f.busy, maxRetries)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	var f *fakeAzure
+	backendtest.Run(t, &backendtest.Harness{
+		New: func(t *testing.T) backend.RouteManager {
+			f = newFakeAzure(t)
+			return newTestRouteManager(t, f, &Config{ResourceGroup: "rg", SubscriptionID: "sub"})
+		},
+		Routes: func(t *testing.T) map[string]string {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			m := make(map[string]string)
+			for _, r := range f.routes {
+				m[r.Properties.AddressPrefix] = r.Properties.NextHopIPAddress
+			}
+			return m
+		},
+		AddForeignRoute: func(t *testing.T, ip, subnet string) {
+			f.add("manual-"+replacer.Replace(subnet), subnet, "VirtualAppliance", ip)
+		},
+	})
+}
@@ -0,0 +1,197 @@
+// Package backendtest checks that a backend.RouteManager honors the
+// interface contract:
+//
+//   - Insert is idempotent and replaces the next hop of an existing route.
+//   - Delete of a missing route succeeds and changes nothing.
+//   - Sync converges: afterwards the owned routes match the input and a
+//     second Sync with the same input reports every route unchanged.
+//   - Sync reports a route whose next hop changed as replaced.
+//   - DeleteAllRoutes and Sync only ever remove owned routes.
+//
+// Call Run from a test in the backend package with a Harness wired to a
+// fake or scratch instance of the backend.
+package backendtest
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+type Harness struct {
+	// New returns a route manager backed by an empty route table.
+	New func(t *testing.T) backend.RouteManager
+	// Routes returns every route in the route table, owned or not, as a map
+	// of subnet to next hop IP.
+	Routes func(t *testing.T) map[string]string
+	// AddForeignRoute adds a route the route manager must not touch. The
+	// ownership checks are skipped when nil.
+	AddForeignRoute func(t *testing.T, ip, subnet string)
+}
+
+func Run(t *testing.T, h *Harness) {
+	t.Run("InsertIdempotent", func(t *testing.T) { testInsertIdempotent(t, h) })
+	t.Run("InsertReplacesNextHop", func(t *testing.T) { testInsertReplacesNextHop(t, h) })
+	t.Run("DeleteMissing", func(t *testing.T) { testDeleteMissing(t, h) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, h) })
+	t.Run("SyncConverges", func(t *testing.T) { testSyncConverges(t, h) })
+	t.Run("SyncMovesSubnet", func(t *testing.T) { testSyncMovesSubnet(t, h) })
+	t.Run("SyncHostWithTwoSubnets", func(t *testing.T) { testSyncHostWithTwoSubnets(t, h) })
+	if h.AddForeignRoute != nil {
+		t.Run("SyncKeepsForeignRoutes", func(t *testing.T) { testSyncKeepsForeignRoutes(t, h) })
+		t.Run("DeleteAllRoutesKeepsForeignRoutes", func(t *testing.T) { testDeleteAllRoutesKeepsForeignRoutes(t, h) })
+	}
+}
+
+func testInsertIdempotent(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	first, err := rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	second, err := rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if err != nil {
+		t.Fatalf("second Insert: %v", err)
+	}
+	if first != second {
+		t.Errorf("Insert returned %q, then %q", first, second)
+	}
+	expectRoutes(t, h, map[string]string{"10.244.1.0/24": "10.240.0.2"})
+}
+
+func testInsertReplacesNextHop(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	mustInsert(t, rm, "10.240.0.2", "10.244.1.0/24")
+	mustInsert(t, rm, "10.240.0.3", "10.244.1.0/24")
+	expectRoutes(t, h, map[string]string{"10.244.1.0/24": "10.240.0.3"})
+}
+
+func testDeleteMissing(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	mustInsert(t, rm, "10.240.0.2", "10.244.1.0/24")
+	if _, err := rm.Delete("10.244.2.0/24"); err != nil {
+		t.Errorf("Delete of a route never inserted: %v", err)
+	}
+	expectRoutes(t, h, map[string]string{"10.244.1.0/24": "10.240.0.2"})
+}
+
+func testDelete(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	mustInsert(t, rm, "10.240.0.2", "10.244.1.0/24")
+	mustInsert(t, rm, "10.240.0.3", "10.244.2.0/24")
+	if _, err := rm.Delete("10.244.1.0/24"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := rm.Delete("10.244.1.0/24"); err != nil {
+		t.Errorf("Delete of a deleted route: %v", err)
+	}
+	expectRoutes(t, h, map[string]string{"10.244.2.0/24": "10.240.0.3"})
+}
+
+func testSyncConverges(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	mustInsert(t, rm, "10.240.0.2", "10.244.1.0/24")
+	mustInsert(t, rm, "10.240.0.9", "10.244.9.0/24")
+	in := backend.RouteTable{
+		"10.244.1.0/24": "10.240.0.2",
+		"10.244.2.0/24": "10.240.0.3",
+	}
+	resp, err := rm.Sync(in)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	expectCounts(t, "Sync", resp, 1, 0, 1, 1)
+	expectRoutes(t, h, in)
+	resp, err = rm.Sync(in)
+	if err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	expectCounts(t, "second Sync", resp, 0, 0, 0, 2)
+}
+
+func testSyncMovesSubnet(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	mustInsert(t, rm, "10.240.0.2", "10.244.1.0/24")
+	resp, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.3"})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	expectCounts(t, "Sync", resp, 0, 1, 0, 0)
+	expectRoutes(t, h, backend.RouteTable{"10.244.1.0/24": "10.240.0.3"})
+}
+
+func testSyncHostWithTwoSubnets(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	in := backend.RouteTable{
+		"10.244.1.0/24": "10.240.0.2",
+		"10.244.2.0/24": "10.240.0.2",
+	}
+	resp, err := rm.Sync(in)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	expectCounts(t, "Sync", resp, 2, 0, 0, 0)
+	expectRoutes(t, h, in)
+}
+
+func testSyncKeepsForeignRoutes(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	h.AddForeignRoute(t, "10.240.0.100", "192.168.0.0/24")
+	mustInsert(t, rm, "10.240.0.2", "10.244.1.0/24")
+	if _, err := rm.Sync(backend.RouteTable{}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	expectRoutes(t, h, map[string]string{"192.168.0.0/24": "10.240.0.100"})
+}
+
+func testDeleteAllRoutesKeepsForeignRoutes(t *testing.T, h *Harness) {
+	rm := h.New(t)
+	h.AddForeignRoute(t, "10.240.0.100", "192.168.0.0/24")
+	mustInsert(t, rm, "10.240.0.2", "10.244.1.0/24")
+	mustInsert(t, rm, "10.240.0.3", "10.244.2.0/24")
+	deleted, err := rm.DeleteAllRoutes()
+	if err != nil {
+		t.Fatalf("DeleteAllRoutes: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("DeleteAllRoutes deleted %v, want 2 routes", deleted)
+	}
+	expectRoutes(t, h, map[string]string{"192.168.0.0/24": "10.240.0.100"})
+}
+
+func mustInsert(t *testing.T, rm backend.RouteManager, ip, subnet string) {
+	if _, err := rm.Insert(ip, subnet); err != nil {
+		t.Fatalf("Insert(%s, %s): %v", ip, subnet, err)
+	}
+}
+
+func expectCounts(t *testing.T, op string, resp *backend.SyncResponse, inserted, replaced, deleted, unchanged int) {
+	if len(resp.Inserted) != inserted || len(resp.Replaced) != replaced || len(resp.Deleted) != deleted || len(resp.Unchanged) != unchanged {
+		t.Errorf("%s inserted %v, replaced %v, deleted %v and left %v unchanged, want %d, %d, %d and %d routes",
+			op, resp.Inserted, resp.Replaced, resp.Deleted, resp.Unchanged, inserted, replaced, deleted, unchanged)
+	}
+}
+
+func expectRoutes(t *testing.T, h *Harness, want backend.RouteTable) {
+	got := h.Routes(t)
+	if len(got) != len(want) {
+		t.Errorf("route table is %v, want %v", sorted(got), sorted(want))
+		return
+	}
+	for subnet, ip := range want {
+		if got[subnet] != ip {
+			t.Errorf("route table is %v, want %v", sorted(got), sorted(want))
+			return
+		}
+	}
+}
+
+func sorted(m map[string]string) []string {
+	s := make([]string, 0, len(m))
+	for subnet, ip := range m {
+		s = append(s, subnet+" via "+ip)
+	}
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,202 @@
+package bgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+
+	headerLen     = 19
+	maxMessageLen = 4096
+
+	// asTrans stands in for a 4-octet ASN towards peers that only support
+	// 2-octet ASNs (RFC 6793).
+	asTrans = 23456
+
+	capMultiprotocol = 1
+	cap4OctetAS      = 65
+
+	attrOrigin    = 1
+	attrASPath    = 2
+	attrNextHop   = 3
+	attrLocalPref = 5
+	attrAS4Path   = 17
+
+	flagOptional   = 0x80
+	flagTransitive = 0x40
+
+	asSequence = 2
+)
+
+var marker = bytes.Repeat([]byte{0xff}, 16)
+
+type message struct {
+	typ  uint8
+	body []byte
+}
+
+type openMessage struct {
+	asn      uint32
+	as4      bool
+	holdTime uint16
+	routerID net.IP
+}
+
+func readMessage(r io.Reader) (*message, error) {
+	hdr := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:16], marker) {
+		return nil, errors.New("bgp: invalid message marker")
+	}
+	length := int(binary.BigEndian.Uint16(hdr[16:18]))
+	if length < headerLen || length > maxMessageLen {
+		return nil, fmt.Errorf("bgp: invalid message length %d", length)
+	}
+	body := make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &message{typ: hdr[18], body: body}, nil
+}
+
+func marshalMessage(typ uint8, body []byte) []byte {
+	b := make([]byte, headerLen, headerLen+len(body))
+	copy(b, marker)
+	binary.BigEndian.PutUint16(b[16:18], uint16(headerLen+len(body)))
+	b[18] = typ
+	return append(b, body...)
+}
+
+func marshalOpen(asn uint32, holdTime uint16, routerID net.IP) []byte {
+	myAS := asn
+	if asn > 0xffff {
+		myAS = asTrans
+	}
+	caps := []byte{
+		capMultiprotocol, 4, 0, 1, 0, 1, // IPv4 unicast
+		cap4OctetAS, 4, 0, 0, 0, 0,
+	}
+	binary.BigEndian.PutUint32(caps[8:], asn)
+	params := append([]byte{2, byte(len(caps))}, caps...)
+	body := make([]byte, 10, 10+len(params))
+	body[0] = 4
+	binary.BigEndian.PutUint16(body[1:3], uint16(myAS))
+	binary.BigEndian.PutUint16(body[3:5], holdTime)
+	copy(body[5:9], routerID.To4())
+	body[9] = byte(len(params))
+	return marshalMessage(msgOpen, append(body, params...))
+}
+
+func parseOpen(body []byte) (*openMessage, error) {
+	if len(body) < 10 || len(body) < 10+int(body[9]) {
+		return nil, errors.New("bgp: short OPEN message")
+	}
+	if body[0] != 4 {
+		return nil, fmt.Errorf("bgp: unsupported BGP version %d", body[0])
+	}
+	open := &openMessage{
+		asn:      uint32(binary.BigEndian.Uint16(body[1:3])),
+		holdTime: binary.BigEndian.Uint16(body[3:5]),
+		routerID: net.IP(append([]byte{}, body[5:9]...)),
+	}
+	params := body[10 : 10+int(body[9])]
+	for len(params) >= 2 {
+		typ, l := params[0], int(params[1])
+		if len(params) < 2+l {
+			return nil, errors.New("bgp: malformed OPEN optional parameter")
+		}
+		caps := params[2 : 2+l]
+		params = params[2+l:]
+		if typ != 2 {
+			continue
+		}
+		for len(caps) >= 2 {
+			code, cl := caps[0], int(caps[1])
+			if len(caps) < 2+cl {
+				return nil, errors.New("bgp: malformed OPEN capability")
+			}
+			if code == cap4OctetAS && cl == 4 {
+				open.asn = binary.BigEndian.Uint32(caps[2:6])
+				open.as4 = true
+			}
+			caps = caps[2+cl:]
+		}
+	}
+	return open, nil
+}
+
+func marshalNotification(code, subcode uint8) []byte {
+	return marshalMessage(msgNotification, []byte{code, subcode})
+}
+
+func marshalKeepalive() []byte {
+	return marshalMessage(msgKeepalive, nil)
+}
+
+func marshalUpdate(withdrawn []*net.IPNet, attrs []byte, nlri []*net.IPNet) []byte {
+	var w, n []byte
+	for _, p := range withdrawn {
+		w = append(w, marshalPrefix(p)...)
+	}
+	for _, p := range nlri {
+		n = append(n, marshalPrefix(p)...)
+	}
+	body := make([]byte, 0, 4+len(w)+len(attrs)+len(n))
+	body = append(body, byte(len(w)>>8), byte(len(w)))
+	body = append(body, w...)
+	body = append(body, byte(len(attrs)>>8), byte(len(attrs)))
+	body = append(body, attrs...)
+	body = append(body, n...)
+	return marshalMessage(msgUpdate, body)
+}
+
+func marshalPrefix(p *net.IPNet) []byte {
+	ones, _ := p.Mask.Size()
+	return append([]byte{byte(ones)}, p.IP.To4()[:(ones+7)/8]...)
+}
+
+// pathAttrs returns the path attributes announcing a route with nextHop from
+// localASN to a peer in peerASN.
+func pathAttrs(localASN, peerASN uint32, as4 bool, nextHop net.IP) []byte {
+	b := marshalAttr(flagTransitive, attrOrigin, []byte{0})
+	if localASN == peerASN {
+		b = append(b, marshalAttr(flagTransitive, attrASPath, nil)...)
+		b = append(b, marshalAttr(flagTransitive, attrNextHop, nextHop.To4())...)
+		return append(b, marshalAttr(flagTransitive, attrLocalPref, []byte{0, 0, 0, 100})...)
+	}
+	if as4 {
+		b = append(b, marshalAttr(flagTransitive, attrASPath, asPathSegment(localASN, 4))...)
+	} else if localASN > 0xffff {
+		b = append(b, marshalAttr(flagTransitive, attrASPath, asPathSegment(asTrans, 2))...)
+		b = append(b, marshalAttr(flagOptional|flagTransitive, attrAS4Path, asPathSegment(localASN, 4))...)
+	} else {
+		b = append(b, marshalAttr(flagTransitive, attrASPath, asPathSegment(localASN, 2))...)
+	}
+	return append(b, marshalAttr(flagTransitive, attrNextHop, nextHop.To4())...)
+}
+
+func asPathSegment(asn uint32, size int) []byte {
+	b := make([]byte, 2+size)
+	b[0], b[1] = asSequence, 1
+	if size == 4 {
+		binary.BigEndian.PutUint32(b[2:], asn)
+	} else {
+		binary.BigEndian.PutUint16(b[2:], uint16(asn))
+	}
+	return b
+}
+
+func marshalAttr(flags, typ uint8, value []byte) []byte {
+	return append([]byte{flags, typ, byte(len(value))}, value...)
+}
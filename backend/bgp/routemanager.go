@@ -0,0 +1,206 @@
+package bgp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+const DefaultHoldTime = 90 * time.Second
+
+type Config struct {
+	ASN      uint32
+	HoldTime time.Duration
+	Peers    []Peer
+	RouterID string
+}
+
+type route struct {
+	dst     *net.IPNet
+	nextHop net.IP
+}
+
+// RouteManager is a BGP speaker announcing every flannel subnet to its peers
+// with the subnet's PublicIP as next hop. It only ever connects out to its
+// peers and ignores any routes they send.
+type RouteManager struct {
+	asn      uint32
+	holdTime time.Duration
+	routerID net.IP
+	sessions []*session
+
+	mu  sync.Mutex
+	rib map[string]route
+}
+
+func New(config *Config) (*RouteManager, error) {
+	if config.ASN == 0 {
+		return nil, errors.New("bgp: ASN is required")
+	}
+	if len(config.Peers) == 0 {
+		return nil, errors.New("bgp: at least one peer is required")
+	}
+	routerID := net.ParseIP(config.RouterID).To4()
+	if routerID == nil {
+		return nil, fmt.Errorf("bgp: invalid router ID %q", config.RouterID)
+	}
+	holdTime := config.HoldTime
+	if holdTime == 0 {
+		holdTime = DefaultHoldTime
+	}
+	if holdTime < 3*time.Second || holdTime > 0xffff*time.Second {
+		return nil, fmt.Errorf("bgp: invalid hold time %v", holdTime)
+	}
+	rm := &RouteManager{
+		asn:      config.ASN,
+		holdTime: holdTime,
+		routerID: routerID,
+		rib:      make(map[string]route),
+	}
+	for _, p := range config.Peers {
+		rm.sessions = append(rm.sessions, newSession(rm, p))
+	}
+	for _, s := range rm.sessions {
+		go s.run()
+	}
+	return rm, nil
+}
+
+// Close tears down all peer sessions.
+func (rm *RouteManager) Close() {
+	for _, s := range rm.sessions {
+		s.stop()
+	}
+}
+
+func (rm *RouteManager) Delete(subnet string) (string, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	r, err := parseRoute("", subnet)
+	if err != nil {
+		return subnet, err
+	}
+	rm.withdraw(r)
+	return r.dst.String(), nil
+}
+
+func (rm *RouteManager) DeleteAllRoutes() ([]string, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	deleted := []string{}
+	for name, r := range rm.rib {
+		rm.withdraw(r)
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
+
+func (rm *RouteManager) Insert(ip, subnet string) (string, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	r, err := parseRoute(ip, subnet)
+	if err != nil {
+		return subnet, err
+	}
+	rm.announce(r)
+	return r.dst.String(), nil
+}
+
+func (rm *RouteManager) Plan(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	p, err := rm.plan(routes)
+	if err != nil {
+		return backend.NewSyncResponse(), err
+	}
+	return p.Response(routeName), nil
+}
+
+func (rm *RouteManager) Sync(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	return rm.sync(routes)
+}
+
+func (rm *RouteManager) sync(in backend.RouteTable) (*backend.SyncResponse, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	p, err := rm.plan(in)
+	if err != nil {
+		return backend.NewSyncResponse(), err
+	}
+	for _, name := range p.Delete {
+		rm.withdraw(rm.rib[name])
+	}
+	// A new announcement implicitly replaces the previous one.
+	for _, name := range p.Replace {
+		rm.announce(p.desired[name])
+	}
+	for _, name := range p.Insert {
+		rm.announce(p.desired[name])
+	}
+	return p.Response(routeName), nil
+}
+
+// routePlan is the diff between the RIB and a flannel route table, keyed
+// by destination.
+type routePlan struct {
+	*backend.Changes
+	desired map[string]route
+}
+
+func routeName(subnet string) string {
+	return subnet
+}
+
+// plan compares the RIB with in. The caller must hold rm.mu.
+func (rm *RouteManager) plan(in backend.RouteTable) (*routePlan, error) {
+	desired := make(map[string]route)
+	desiredTable := make(backend.RouteTable)
+	for subnet, ip := range in {
+		r, err := parseRoute(ip, subnet)
+		if err != nil {
+			return nil, err
+		}
+		desired[r.dst.String()] = r
+		desiredTable[r.dst.String()] = r.nextHop.String()
+	}
+	current := make(backend.RouteTable)
+	for name, r := range rm.rib {
+		current[name] = r.nextHop.String()
+	}
+	return &routePlan{Changes: backend.Diff(desiredTable, current), desired: desired}, nil
+}
+
+func (rm *RouteManager) announce(r route) {
+	rm.rib[r.dst.String()] = r
+	for _, s := range rm.sessions {
+		s.announce(r)
+	}
+}
+
+func (rm *RouteManager) withdraw(r route) {
+	delete(rm.rib, r.dst.String())
+	for _, s := range rm.sessions {
+		s.withdraw(r)
+	}
+}
+
+func parseRoute(ip, subnet string) (route, error) {
+	_, dst, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return route{}, err
+	}
+	if dst.IP.To4() == nil {
+		return route{}, fmt.Errorf("bgp: %s is not an IPv4 subnet", subnet)
+	}
+	r := route{dst: dst}
+	if ip != "" {
+		if r.nextHop = net.ParseIP(ip).To4(); r.nextHop == nil {
+			return route{}, fmt.Errorf("bgp: invalid IPv4 next hop %q", ip)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,288 @@
+package bgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// testPeer is a minimal BGP peer listening on the loopback interface.
+type testPeer struct {
+	t        *testing.T
+	listener net.Listener
+	conn     net.Conn
+}
+
+func newTestPeer(t *testing.T) *testPeer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testPeer{t: t, listener: l}
+	t.Cleanup(func() {
+		l.Close()
+		if p.conn != nil {
+			p.conn.Close()
+		}
+	})
+	return p
+}
+
+// accept waits for the speaker to connect and completes the OPEN/KEEPALIVE
+// handshake as asn, returning the speaker's OPEN.
+func (p *testPeer) accept(asn uint32) *openMessage {
+	conn, err := p.listener.Accept()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.conn = conn
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	msg := p.read()
+	if msg.typ != msgOpen {
+		p.t.Fatalf("got message type %d, want OPEN", msg.typ)
+	}
+	open, err := parseOpen(msg.body)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.write(marshalOpen(asn, 90, net.ParseIP("10.240.0.254")))
+	p.write(marshalKeepalive())
+	if msg := p.read(); msg.typ != msgKeepalive {
+		p.t.Fatalf("got message type %d, want KEEPALIVE", msg.typ)
+	}
+	return open
+}
+
+func (p *testPeer) read() *message {
+	msg, err := readMessage(p.conn)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return msg
+}
+
+func (p *testPeer) write(b []byte) {
+	if _, err := p.conn.Write(b); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// readUpdate returns the next UPDATE the speaker sends, skipping KEEPALIVEs.
+func (p *testPeer) readUpdate() *update {
+	for {
+		msg := p.read()
+		switch msg.typ {
+		case msgKeepalive:
+			continue
+		case msgUpdate:
+			u, err := parseUpdate(msg.body)
+			if err != nil {
+				p.t.Fatal(err)
+			}
+			return u
+		default:
+			p.t.Fatalf("got message type %d, want UPDATE", msg.typ)
+		}
+	}
+}
+
+type update struct {
+	withdrawn []string
+	nlri      []string
+	nextHop   string
+	asPath    []byte
+	as4Path   []byte
+}
+
+func parseUpdate(b []byte) (*update, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("short UPDATE")
+	}
+	u := &update{}
+	wl := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < wl+2 {
+		return nil, fmt.Errorf("short withdrawn routes")
+	}
+	withdrawn, err := parsePrefixes(b[:wl])
+	if err != nil {
+		return nil, err
+	}
+	u.withdrawn = withdrawn
+	b = b[wl:]
+	al := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < al {
+		return nil, fmt.Errorf("short path attributes")
+	}
+	attrs := b[:al]
+	for len(attrs) >= 3 {
+		typ, l := attrs[1], int(attrs[2])
+		if len(attrs) < 3+l {
+			return nil, fmt.Errorf("malformed path attribute")
+		}
+		value := attrs[3 : 3+l]
+		switch typ {
+		case attrNextHop:
+			u.nextHop = net.IP(value).String()
+		case attrASPath:
+			u.asPath = value
+		case attrAS4Path:
+			u.as4Path = value
+		}
+		attrs = attrs[3+l:]
+	}
+	if u.nlri, err = parsePrefixes(b[al:]); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func parsePrefixes(b []byte) ([]string, error) {
+	var prefixes []string
+	for len(b) > 0 {
+		ones := int(b[0])
+		n := (ones + 7) / 8
+		if ones > 32 || len(b) < 1+n {
+			return nil, fmt.Errorf("malformed prefix")
+		}
+		ip := make(net.IP, 4)
+		copy(ip, b[1:1+n])
+		prefixes = append(prefixes, (&net.IPNet{IP: ip, Mask: net.CIDRMask(ones, 32)}).String())
+		b = b[1+n:]
+	}
+	return prefixes, nil
+}
+
+func newTestRouteManager(t *testing.T, asn uint32, peers ...Peer) *RouteManager {
+	rm, err := New(&Config{ASN: asn, Peers: peers, RouterID: "10.240.0.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rm.Close)
+	return rm
+}
+
+func TestSession(t *testing.T) {
+	p := newTestPeer(t)
+	rm := newTestRouteManager(t, 65000, Peer{Address: p.listener.Addr().String(), ASN: 65001})
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+
+	open := p.accept(65001)
+	if open.asn != 65000 || !open.as4 || open.holdTime != 90 || open.routerID.String() != "10.240.0.10" {
+		t.Errorf("got OPEN %+v", open)
+	}
+	u := p.readUpdate()
+	if len(u.nlri) != 1 || u.nlri[0] != "10.244.1.0/24" || u.nextHop != "10.240.0.2" {
+		t.Errorf("initial UPDATE announces %v via %s, want 10.244.1.0/24 via 10.240.0.2", u.nlri, u.nextHop)
+	}
+	if want := []byte{asSequence, 1, 0, 0, 0xfd, 0xe8}; string(u.asPath) != string(want) {
+		t.Errorf("AS_PATH is %v, want %v", u.asPath, want)
+	}
+
+	resp, err := rm.Sync(map[string]string{"10.244.1.0/24": "10.240.0.3", "10.244.2.0/24": "10.240.0.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Replaced) != 1 || len(resp.Inserted) != 1 {
+		t.Errorf("Sync replaced %v and inserted %v", resp.Replaced, resp.Inserted)
+	}
+	got := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		u := p.readUpdate()
+		for _, prefix := range u.nlri {
+			got[prefix] = u.nextHop
+		}
+	}
+	if got["10.244.1.0/24"] != "10.240.0.3" || got["10.244.2.0/24"] != "10.240.0.4" || len(got) != 2 {
+		t.Errorf("Sync announced %v", got)
+	}
+
+	if _, err := rm.Delete("10.244.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	u = p.readUpdate()
+	if len(u.withdrawn) != 1 || u.withdrawn[0] != "10.244.1.0/24" || len(u.nlri) != 0 {
+		t.Errorf("Delete sent withdrawn %v and NLRI %v, want 10.244.1.0/24 withdrawn", u.withdrawn, u.nlri)
+	}
+}
+
+func TestSessionAS4PathTo2OctetPeer(t *testing.T) {
+	p := newTestPeer(t)
+	rm := newTestRouteManager(t, 4200000000, Peer{Address: p.listener.Addr().String(), ASN: 65001})
+
+	conn, err := p.listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.conn = conn
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	msg := p.read()
+	if got := binary.BigEndian.Uint16(msg.body[1:3]); got != asTrans {
+		t.Errorf("My Autonomous System is %d, want AS_TRANS", got)
+	}
+	// An OPEN without capabilities, as sent by a 2-octet ASN speaker.
+	body := []byte{4, 0xfd, 0xe9, 0, 90, 10, 240, 0, 254, 0}
+	p.write(marshalMessage(msgOpen, body))
+	p.write(marshalKeepalive())
+	if msg := p.read(); msg.typ != msgKeepalive {
+		t.Fatalf("got message type %d, want KEEPALIVE", msg.typ)
+	}
+
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	u := p.readUpdate()
+	if want := []byte{asSequence, 1, 0x5b, 0xa0}; string(u.asPath) != string(want) {
+		t.Errorf("AS_PATH is %v, want %v", u.asPath, want)
+	}
+	if want := []byte{asSequence, 1, 0xfa, 0x56, 0xea, 0x00}; string(u.as4Path) != string(want) {
+		t.Errorf("AS4_PATH is %v, want %v", u.as4Path, want)
+	}
+}
+
+func TestSessionRejectsWrongASN(t *testing.T) {
+	p := newTestPeer(t)
+	newTestRouteManager(t, 65000, Peer{Address: p.listener.Addr().String(), ASN: 65001})
+
+	conn, err := p.listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.conn = conn
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	p.read()
+	p.write(marshalOpen(65002, 90, net.ParseIP("10.240.0.254")))
+	msg := p.read()
+	if msg.typ != msgNotification || len(msg.body) < 2 || msg.body[0] != 2 || msg.body[1] != 2 {
+		t.Errorf("got message type %d %v, want NOTIFICATION 2/2 (bad peer AS)", msg.typ, msg.body)
+	}
+}
+
+func TestMarshalUpdateRoundTrip(t *testing.T) {
+	_, withdrawn, _ := net.ParseCIDR("10.244.0.0/15")
+	_, nlri, _ := net.ParseCIDR("10.244.3.128/25")
+	b := marshalUpdate([]*net.IPNet{withdrawn}, pathAttrs(65000, 65000, true, net.ParseIP("10.240.0.2")), []*net.IPNet{nlri})
+	msg, err := readMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := parseUpdate(msg.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.withdrawn) != 1 || u.withdrawn[0] != "10.244.0.0/15" {
+		t.Errorf("withdrawn %v", u.withdrawn)
+	}
+	if len(u.nlri) != 1 || u.nlri[0] != "10.244.3.128/25" || u.nextHop != "10.240.0.2" {
+		t.Errorf("NLRI %v via %s", u.nlri, u.nextHop)
+	}
+	if len(u.asPath) != 0 {
+		t.Errorf("iBGP AS_PATH is %v, want empty", u.asPath)
+	}
+}
@@ -0,0 +1,223 @@
+package bgp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const connectRetry = 30 * time.Second
+
+type Peer struct {
+	// Address is host[:port]; the port defaults to 179.
+	Address string
+	ASN     uint32
+}
+
+// ParsePeer parses a peer in the form asn@host[:port].
+func ParsePeer(s string) (Peer, error) {
+	parts := strings.SplitN(s, "@", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Peer{}, fmt.Errorf("bgp: invalid peer %q, expected asn@host[:port]", s)
+	}
+	asn, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return Peer{}, fmt.Errorf("bgp: invalid peer ASN %q", parts[0])
+	}
+	return Peer{Address: parts[1], ASN: uint32(asn)}, nil
+}
+
+type session struct {
+	peer     Peer
+	address  string
+	rm       *RouteManager
+	stopChan chan bool
+
+	mu   sync.Mutex
+	conn net.Conn
+	as4  bool
+}
+
+func newSession(rm *RouteManager, peer Peer) *session {
+	address := peer.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "179")
+	}
+	return &session{
+		peer:     peer,
+		address:  address,
+		rm:       rm,
+		stopChan: make(chan bool),
+	}
+}
+
+func (s *session) run() {
+	for {
+		err := s.establish()
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+		log.Printf("bgp: peer %s: %v\n", s.address, err)
+		select {
+		case <-s.stopChan:
+			return
+		case <-time.After(connectRetry):
+		}
+	}
+}
+
+func (s *session) stop() {
+	close(s.stopChan)
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+// establish connects to the peer, announces the current routes once the
+// session is up and then blocks until the session goes down.
+func (s *session) establish() error {
+	conn, err := net.DialTimeout("tcp", s.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	holdTime := uint16(s.rm.holdTime / time.Second)
+	if _, err := conn.Write(marshalOpen(s.rm.asn, holdTime, s.rm.routerID)); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(4 * time.Minute))
+	msg, err := readMessage(conn)
+	if err != nil {
+		return err
+	}
+	if msg.typ != msgOpen {
+		conn.Write(marshalNotification(5, 0))
+		return fmt.Errorf("bgp: expected OPEN, got message type %d", msg.typ)
+	}
+	open, err := parseOpen(msg.body)
+	if err != nil {
+		conn.Write(marshalNotification(2, 0))
+		return err
+	}
+	if open.asn != s.peer.ASN {
+		conn.Write(marshalNotification(2, 2))
+		return fmt.Errorf("bgp: peer ASN %d, expected %d", open.asn, s.peer.ASN)
+	}
+	if open.holdTime == 1 || open.holdTime == 2 {
+		conn.Write(marshalNotification(2, 6))
+		return fmt.Errorf("bgp: unacceptable hold time %d", open.holdTime)
+	}
+	if open.holdTime < holdTime {
+		holdTime = open.holdTime
+	}
+	if _, err := conn.Write(marshalKeepalive()); err != nil {
+		return err
+	}
+	if err := s.readUntilKeepalive(conn, holdTime); err != nil {
+		return err
+	}
+	log.Printf("bgp: peer %s: session established\n", s.address)
+
+	s.rm.mu.Lock()
+	s.mu.Lock()
+	s.conn = conn
+	s.as4 = open.as4
+	s.mu.Unlock()
+	for _, r := range s.rm.rib {
+		s.announce(r)
+	}
+	s.rm.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+
+	if holdTime > 0 {
+		done := make(chan bool)
+		defer close(done)
+		go s.keepalive(time.Duration(holdTime)*time.Second/3, done)
+	}
+	for {
+		if err := s.readUntilKeepalive(conn, holdTime); err != nil {
+			return err
+		}
+	}
+}
+
+// readUntilKeepalive reads and discards messages from the peer until a
+// KEEPALIVE arrives. Received routes are ignored.
+func (s *session) readUntilKeepalive(conn net.Conn, holdTime uint16) error {
+	for {
+		if holdTime > 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(holdTime) * time.Second))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		msg, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+		switch msg.typ {
+		case msgKeepalive:
+			return nil
+		case msgNotification:
+			if len(msg.body) < 2 {
+				return errors.New("bgp: received NOTIFICATION")
+			}
+			return fmt.Errorf("bgp: received NOTIFICATION %d/%d", msg.body[0], msg.body[1])
+		case msgUpdate:
+		default:
+			return fmt.Errorf("bgp: unexpected message type %d", msg.typ)
+		}
+	}
+}
+
+func (s *session) keepalive(interval time.Duration, done chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+			s.send(marshalKeepalive())
+		}
+	}
+}
+
+func (s *session) announce(r route) {
+	s.mu.Lock()
+	as4 := s.as4
+	s.mu.Unlock()
+	attrs := pathAttrs(s.rm.asn, s.peer.ASN, as4, r.nextHop)
+	s.send(marshalUpdate(nil, attrs, []*net.IPNet{r.dst}))
+}
+
+func (s *session) withdraw(r route) {
+	s.send(marshalUpdate([]*net.IPNet{r.dst}, nil, nil))
+}
+
+// send writes msg to the peer if the session is established. A failed write
+// closes the connection, which makes establish reconnect and re-announce
+// every route.
+func (s *session) send(msg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.conn.Write(msg); err != nil {
+		log.Printf("bgp: peer %s: %v\n", s.address, err)
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -0,0 +1,62 @@
+package backend
+
+import "sort"
+
+// Changes lists the subnets that differ between two route tables, each list
+// in sorted order.
+type Changes struct {
+	Delete    []string
+	Insert    []string
+	Replace   []string
+	Unchanged []string
+}
+
+// Diff compares the desired route table with the current one.
+func Diff(desired, current RouteTable) *Changes {
+	c := &Changes{
+		Delete:    []string{},
+		Insert:    []string{},
+		Replace:   []string{},
+		Unchanged: []string{},
+	}
+	for subnet := range current {
+		if _, ok := desired[subnet]; !ok {
+			c.Delete = append(c.Delete, subnet)
+		}
+	}
+	for subnet, ip := range desired {
+		currentIP, ok := current[subnet]
+		switch {
+		case !ok:
+			c.Insert = append(c.Insert, subnet)
+		case currentIP != ip:
+			c.Replace = append(c.Replace, subnet)
+		default:
+			c.Unchanged = append(c.Unchanged, subnet)
+		}
+	}
+	sort.Strings(c.Delete)
+	sort.Strings(c.Insert)
+	sort.Strings(c.Replace)
+	sort.Strings(c.Unchanged)
+	return c
+}
+
+// Response reports the changes as a SyncResponse, naming the route to each
+// subnet with name.
+func (c *Changes) Response(name func(subnet string) string) *SyncResponse {
+	response := NewSyncResponse()
+	for _, subnet := range c.Delete {
+		response.Deleted = append(response.Deleted, name(subnet))
+	}
+	for _, subnet := range c.Insert {
+		response.Inserted = append(response.Inserted, name(subnet))
+	}
+	for _, subnet := range c.Replace {
+		response.Replaced = append(response.Replaced, name(subnet))
+	}
+	for _, subnet := range c.Unchanged {
+		response.Unchanged = append(response.Unchanged, name(subnet))
+	}
+	return response
+}
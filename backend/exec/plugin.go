@@ -0,0 +1,95 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend/remote"
+)
+
+type plugin struct {
+	args    []string
+	path    string
+	timeout time.Duration
+}
+
+// Call runs the plugin once for req. The plugin is killed when it doesn't
+// exit within the timeout. Anything it writes to stderr is logged.
+func (p *plugin) Call(req *remote.Request) (*remote.Response, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var stdout bytes.Buffer
+	stderr := &logWriter{prefix: fmt.Sprintf("exec: %s %s: ", filepath.Base(p.path), req.Command)}
+	cmd := exec.Command(p.path, p.args...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	timedOut := false
+	timer := time.AfterFunc(p.timeout, func() {
+		mu.Lock()
+		timedOut = true
+		mu.Unlock()
+		kill(cmd)
+	})
+	waitErr := cmd.Wait()
+	timer.Stop()
+	stderr.Flush()
+	mu.Lock()
+	defer mu.Unlock()
+	if timedOut {
+		return nil, fmt.Errorf("exec: %s %s: timed out after %v", p.path, req.Command, p.timeout)
+	}
+	var resp remote.Response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		if waitErr != nil {
+			return nil, fmt.Errorf("exec: %s %s: %v", p.path, req.Command, waitErr)
+		}
+		return nil, fmt.Errorf("exec: %s %s: invalid response: %v", p.path, req.Command, err)
+	}
+	if resp.Error != "" {
+		return &resp, fmt.Errorf("exec: %s %s: %s", p.path, req.Command, resp.Error)
+	}
+	if waitErr != nil {
+		return &resp, fmt.Errorf("exec: %s %s: %v", p.path, req.Command, waitErr)
+	}
+	return &resp, nil
+}
+
+// logWriter logs every complete line written to it.
+type logWriter struct {
+	prefix string
+	buf    []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		log.Printf("%s%s\n", w.prefix, w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) Flush() {
+	if len(w.buf) > 0 {
+		log.Printf("%s%s\n", w.prefix, w.buf)
+		w.buf = nil
+	}
+}
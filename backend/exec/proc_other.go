@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package exec
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func kill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
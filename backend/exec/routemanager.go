@@ -0,0 +1,41 @@
+package exec
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend/remote"
+)
+
+const DefaultTimeout = 60 * time.Second
+
+type Config struct {
+	Args    []string
+	Path    string
+	Timeout time.Duration
+}
+
+// RouteManager forwards every call to an external plugin program, see
+// README.md for the protocol.
+type RouteManager struct {
+	*remote.RouteManager
+}
+
+func New(config *Config) (*RouteManager, error) {
+	if config.Path == "" {
+		return nil, errors.New("exec: plugin path is required")
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	p := &plugin{
+		args:    config.Args,
+		path:    config.Path,
+		timeout: timeout,
+	}
+	rm := &RouteManager{
+		RouteManager: remote.NewRouteManager("exec: "+config.Path, p),
+	}
+	return rm, nil
+}
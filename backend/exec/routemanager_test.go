@@ -0,0 +1,225 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/backendtest"
+	"github.com/kelseyhightower/flannel-route-manager/backend/memory"
+	"github.com/kelseyhightower/flannel-route-manager/backend/remote"
+)
+
+const helperEnv = "EXEC_TEST_PLUGIN"
+
+// pluginState is the route table of the test plugin, kept in a file between
+// runs.
+type pluginState struct {
+	Owned   map[string]string `json:"owned"`
+	Foreign map[string]string `json:"foreign"`
+}
+
+func readState(path string) (*pluginState, error) {
+	state := &pluginState{Owned: map[string]string{}, Foreign: map[string]string{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, json.Unmarshal(data, state)
+}
+
+func writeState(path string, state *pluginState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// TestHelperProcess is the plugin run by the tests below. It serves the
+// route table in the file named by its first argument from a memory route
+// manager. The second argument selects a misbehaviour.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: -- state [mode]")
+		os.Exit(2)
+	}
+	path, mode := args[1], ""
+	if len(args) > 2 {
+		mode = args[2]
+	}
+	switch mode {
+	case "hang":
+		// The child keeps stdout open after the plugin is killed, unless
+		// it is killed too.
+		cmd := exec.Command("sleep", "60")
+		cmd.Stdout = os.Stdout
+		cmd.Start()
+		time.Sleep(time.Minute)
+	case "crash":
+		fmt.Fprint(os.Stderr, "panic: first line\nsecond line")
+		os.Exit(1)
+	}
+	var req remote.Request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	state, err := readState(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	rm := memory.New()
+	for subnet, ip := range state.Foreign {
+		rm.AddForeignRoute(ip, subnet)
+	}
+	for subnet, ip := range state.Owned {
+		rm.Insert(ip, subnet)
+	}
+	resp := remote.Handle(rm, &req)
+	state.Owned = rm.Routes()
+	for subnet := range state.Foreign {
+		delete(state.Owned, subnet)
+	}
+	if err := writeState(path, state); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	json.NewEncoder(os.Stdout).Encode(resp)
+	if resp.Error != "" {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// newTestRouteManager returns a route manager running TestHelperProcess as
+// its plugin, and the path of the plugin's state file.
+func newTestRouteManager(t *testing.T, mode string, timeout time.Duration) (*RouteManager, string) {
+	t.Setenv(helperEnv, "1")
+	path := filepath.Join(t.TempDir(), "routes.json")
+	rm, err := New(&Config{
+		Path:    os.Args[0],
+		Args:    []string{"-test.run=^TestHelperProcess$", "--", path, mode},
+		Timeout: timeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm, path
+}
+
+func TestConformance(t *testing.T) {
+	var path string
+	backendtest.Run(t, &backendtest.Harness{
+		New: func(t *testing.T) backend.RouteManager {
+			var rm *RouteManager
+			rm, path = newTestRouteManager(t, "", 0)
+			return rm
+		},
+		Routes: func(t *testing.T) map[string]string {
+			state, err := readState(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes := make(map[string]string)
+			for subnet, ip := range state.Owned {
+				routes[subnet] = ip
+			}
+			for subnet, ip := range state.Foreign {
+				routes[subnet] = ip
+			}
+			return routes
+		},
+		AddForeignRoute: func(t *testing.T, ip, subnet string) {
+			state, err := readState(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state.Foreign[subnet] = ip
+			if err := writeState(path, state); err != nil {
+				t.Fatal(err)
+			}
+		},
+	})
+}
+
+func TestPluginError(t *testing.T) {
+	rm, path := newTestRouteManager(t, "", 0)
+	if err := writeState(path, &pluginState{Foreign: map[string]string{"10.244.1.0/24": "10.240.0.100"}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if err == nil || !strings.HasSuffix(err.Error(), " insert: memory: 10.244.1.0/24 is taken by a foreign route") {
+		t.Errorf("Insert returned %v, want the plugin's error", err)
+	}
+	resp, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.2", "10.244.2.0/24": "10.240.0.3"})
+	if err == nil || !strings.HasSuffix(err.Error(), ": sync: 1 routes failed") {
+		t.Errorf("Sync returned %v", err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Route != "10.244.1.0/24" || len(resp.Inserted) != 1 {
+		t.Errorf("Sync inserted %v with errors %v", resp.Inserted, resp.Errors)
+	}
+}
+
+func TestPluginCrash(t *testing.T) {
+	rm, _ := newTestRouteManager(t, "crash", 0)
+	_, err := rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if err == nil || !strings.HasSuffix(err.Error(), " insert: exit status 1") {
+		t.Errorf("Insert returned %v, want the exit status", err)
+	}
+}
+
+func TestPluginTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not found")
+	}
+	rm, _ := newTestRouteManager(t, "hang", 500*time.Millisecond)
+	start := time.Now()
+	_, err := rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if err == nil || !strings.HasSuffix(err.Error(), " insert: timed out after 500ms") {
+		t.Errorf("Insert returned %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("Insert returned after %v, want the plugin and its children killed", d)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	rm, path := newTestRouteManager(t, "", 0)
+	if _, err := rm.Insert("10.240.0.9", "10.244.9.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	var planner backend.Planner = rm
+	resp, err := planner.Plan(backend.RouteTable{"10.244.1.0/24": "10.240.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Inserted) != 1 || resp.Inserted[0] != "10.244.1.0/24" || len(resp.Deleted) != 1 || resp.Deleted[0] != "10.244.9.0/24" {
+		t.Errorf("Plan inserts %v and deletes %v", resp.Inserted, resp.Deleted)
+	}
+	state, err := readState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Owned) != 1 || state.Owned["10.244.9.0/24"] != "10.240.0.9" {
+		t.Errorf("Plan changed the routes to %v", state.Owned)
+	}
+}
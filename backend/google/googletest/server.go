@@ -0,0 +1,424 @@
+// Package googletest provides a fake of the parts of the GCE Compute API the
+// google backend uses: projects.get, networks.get, instances.aggregatedList,
+// routes.list, routes.get, routes.insert, routes.delete and
+// globalOperations.get.
+//
+// Route changes take effect when the operation is created. Operations report
+// RUNNING for OperationPolls polls before they are DONE. Failures can be
+// injected per method and route.
+package googletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+)
+
+// Failure makes matching requests fail, either right away with an HTTP
+// status or later with an operation error.
+type Failure struct {
+	// Method is one of projects.get, networks.get, instances.aggregatedList,
+	// routes.list, routes.get, routes.insert, routes.delete and
+	// globalOperations.get. Empty matches all methods.
+	Method string
+	// Route is the name of the route. Empty matches all routes.
+	Route string
+	// Code is the HTTP status of the response. When zero the operation
+	// finishes with OperationError instead, and nothing changes.
+	Code           int
+	OperationError string
+	// Times is the number of requests to fail, zero meaning all.
+	Times int
+
+	message string
+}
+
+type Server struct {
+	// URL is the Compute API base URL of the server, for google.Config
+	// Endpoint.
+	URL string
+	// PageSize is the number of routes per page of routes.list unless the
+	// request asks for fewer.
+	PageSize int
+	// OperationPolls is the number of globalOperations.get calls an
+	// operation reports RUNNING for.
+	OperationPolls int
+	// Project is the project of the networks and routes. Instances may also
+	// live in Shared VPC service projects, see AddServiceInstance.
+	Project string
+	// RoutesQuota is the ROUTES quota of the project. Inserts beyond it
+	// fail with QUOTA_EXCEEDED.
+	RoutesQuota int
+
+	mu         sync.Mutex
+	failures   []*Failure
+	instances  map[string]map[string][]*compute.Instance
+	networks   map[string]*compute.Network
+	operations map[string]*operation
+	requests   map[string]int
+	routes     map[string]*compute.Route
+	server     *httptest.Server
+	nextID     int
+}
+
+type operation struct {
+	op    *compute.Operation
+	polls int
+}
+
+// NewServer starts a fake with the given networks in project.
+func NewServer(project string, networks ...string) *Server {
+	s := &Server{
+		PageSize:    500,
+		Project:     project,
+		RoutesQuota: 250,
+		instances:   make(map[string]map[string][]*compute.Instance),
+		networks:    make(map[string]*compute.Network),
+		operations:  make(map[string]*operation),
+		requests:    make(map[string]int),
+		routes:      make(map[string]*compute.Route),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL + "/compute/v1/projects/"
+	for _, name := range networks {
+		s.networks[name] = &compute.Network{
+			Name:     name,
+			SelfLink: s.selfLink("global/networks/" + name),
+		}
+	}
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Network returns the self-link of the network name.
+func (s *Server) Network(name string) string {
+	return s.selfLink("global/networks/" + name)
+}
+
+// AddRoute adds route as if created by someone else. Its network defaults
+// to the first network of the server.
+func (s *Server) AddRoute(route *compute.Route) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := *route
+	if ns := s.sortedNetworks(); r.Network == "" && len(ns) > 0 {
+		r.Network = ns[0].SelfLink
+	}
+	r.SelfLink = s.selfLink("global/routes/" + r.Name)
+	s.routes[r.Name] = &r
+}
+
+// AddInstance adds instance to zone. Its interfaces name their network by
+// self-link, see Network.
+func (s *Server) AddInstance(zone string, instance *compute.Instance) {
+	s.AddServiceInstance(s.Project, zone, instance)
+}
+
+// AddServiceInstance adds instance to zone of project, a Shared VPC service
+// project of Project. Service projects only serve instances.aggregatedList.
+func (s *Server) AddServiceInstance(project, zone string, instance *compute.Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := *instance
+	i.SelfLink = fmt.Sprintf("%s%s/zones/%s/instances/%s", s.URL, project, zone, i.Name)
+	if s.instances[project] == nil {
+		s.instances[project] = make(map[string][]*compute.Instance)
+	}
+	s.instances[project]["zones/"+zone] = append(s.instances[project]["zones/"+zone], &i)
+}
+
+// Routes returns a copy of all routes ordered by name.
+func (s *Server) Routes() []*compute.Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs := make([]*compute.Route, 0, len(s.routes))
+	for _, r := range s.routes {
+		c := *r
+		rs = append(rs, &c)
+	}
+	sort.Sort(byName(rs))
+	return rs
+}
+
+// Fail injects f.
+func (s *Server) Fail(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.message = "injected failure"
+	s.failures = append(s.failures, &f)
+}
+
+// Requests returns the number of requests made to method.
+func (s *Server) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/compute/v1/projects/")
+	parts := strings.Split(path, "/")
+	known := parts[0] == s.Project
+	if _, ok := s.instances[parts[0]]; ok && len(parts) == 3 && parts[1] == "aggregated" {
+		// Service projects only list their instances.
+		known = true
+	}
+	if !known || (len(parts) != 1 && (len(parts) < 3 || (parts[1] != "global" && parts[1] != "aggregated"))) {
+		writeError(w, http.StatusNotFound, "notFound", "unknown resource "+r.URL.Path)
+		return
+	}
+	var method, name string
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		method = "projects.get"
+	case parts[1] == "aggregated":
+		if parts[2] != "instances" || len(parts) != 3 || r.Method != "GET" {
+			writeError(w, http.StatusNotFound, "notFound", "unknown resource "+r.URL.Path)
+			return
+		}
+		method = "instances.aggregatedList"
+	case parts[2] == "networks" && len(parts) == 4 && r.Method == "GET":
+		method, name = "networks.get", parts[3]
+	case parts[2] == "routes" && len(parts) == 3 && r.Method == "GET":
+		method = "routes.list"
+	case parts[2] == "routes" && len(parts) == 3 && r.Method == "POST":
+		var body struct {
+			compute.Route
+			Priority *int64 `json:"priority"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		// Like GCE, default the priority when it isn't sent.
+		body.Route.Priority = 1000
+		if body.Priority != nil {
+			body.Route.Priority = *body.Priority
+		}
+		s.insertRoute(w, &body.Route)
+		return
+	case parts[2] == "routes" && len(parts) == 4 && r.Method == "GET":
+		method, name = "routes.get", parts[3]
+	case parts[2] == "routes" && len(parts) == 4 && r.Method == "DELETE":
+		method, name = "routes.delete", parts[3]
+	case parts[2] == "operations" && len(parts) == 4 && r.Method == "GET":
+		method, name = "globalOperations.get", parts[3]
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown resource "+r.URL.Path)
+		return
+	}
+	s.requests[method]++
+	f := s.failure(method, name)
+	if f != nil && f.Code != 0 {
+		writeError(w, f.Code, "injected", fmt.Sprintf("injected failure of %s", method))
+		return
+	}
+	switch method {
+	case "projects.get":
+		writeJSON(w, &compute.Project{
+			Name: s.Project,
+			Quotas: []*compute.Quota{
+				{Metric: "ROUTES", Limit: float64(s.RoutesQuota), Usage: float64(len(s.routes))},
+			},
+		})
+	case "networks.get":
+		n, ok := s.networks[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "network "+name+" not found")
+			return
+		}
+		writeJSON(w, n)
+	case "instances.aggregatedList":
+		list := &compute.InstanceAggregatedList{Items: make(map[string]compute.InstancesScopedList)}
+		for zone, instances := range s.instances[parts[0]] {
+			list.Items[zone] = compute.InstancesScopedList{Instances: instances}
+		}
+		writeJSON(w, list)
+	case "routes.list":
+		s.listRoutes(w, r)
+	case "routes.get":
+		route, ok := s.routes[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "route "+name+" not found")
+			return
+		}
+		writeJSON(w, route)
+	case "routes.delete":
+		if _, ok := s.routes[name]; !ok {
+			writeError(w, http.StatusNotFound, "notFound", "route "+name+" not found")
+			return
+		}
+		if f == nil {
+			delete(s.routes, name)
+		}
+		s.writeOperation(w, "delete", name, f)
+	case "globalOperations.get":
+		o, ok := s.operations[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "operation "+name+" not found")
+			return
+		}
+		o.polls++
+		if o.polls > s.OperationPolls {
+			o.op.Status = "DONE"
+		}
+		writeJSON(w, o.op)
+	}
+}
+
+func (s *Server) insertRoute(w http.ResponseWriter, route *compute.Route) {
+	s.requests["routes.insert"]++
+	f := s.failure("routes.insert", route.Name)
+	if f != nil && f.Code != 0 {
+		writeError(w, f.Code, "injected", "injected failure of routes.insert")
+		return
+	}
+	if _, ok := s.routes[route.Name]; ok {
+		writeError(w, http.StatusConflict, "alreadyExists", "route "+route.Name+" already exists")
+		return
+	}
+	if f == nil && len(s.routes) >= s.RoutesQuota {
+		f = &Failure{OperationError: "QUOTA_EXCEEDED", message: fmt.Sprintf("Quota 'ROUTES' exceeded. Limit: %d", s.RoutesQuota)}
+	}
+	if f == nil {
+		route.SelfLink = s.selfLink("global/routes/" + route.Name)
+		s.routes[route.Name] = route
+	}
+	s.writeOperation(w, "insert", route.Name, f)
+}
+
+// listRoutes serves a page of the routes matching the filter, which only
+// supports the "name eq <regexp>" form.
+func (s *Server) listRoutes(w http.ResponseWriter, r *http.Request) {
+	var re *regexp.Regexp
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		fields := strings.Fields(filter)
+		if len(fields) != 3 || fields[0] != "name" || fields[1] != "eq" {
+			writeError(w, http.StatusBadRequest, "invalid", "unsupported filter "+filter)
+			return
+		}
+		var err error
+		re, err = regexp.Compile("^(?:" + fields[2] + ")$")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+	}
+	rs := make([]*compute.Route, 0)
+	for _, route := range s.routes {
+		if re == nil || re.MatchString(route.Name) {
+			rs = append(rs, route)
+		}
+	}
+	sort.Sort(byName(rs))
+	size := s.PageSize
+	if n, err := strconv.Atoi(r.URL.Query().Get("maxResults")); err == nil && n > 0 && n < size {
+		size = n
+	}
+	start := 0
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 0 || n > len(rs) {
+			writeError(w, http.StatusBadRequest, "invalid", "invalid page token "+token)
+			return
+		}
+		start = n
+	}
+	list := &compute.RouteList{Items: rs[start:]}
+	if end := start + size; end < len(rs) {
+		list.Items = rs[start:end]
+		list.NextPageToken = strconv.Itoa(end)
+	}
+	writeJSON(w, list)
+}
+
+func (s *Server) writeOperation(w http.ResponseWriter, kind, route string, f *Failure) {
+	s.nextID++
+	op := &compute.Operation{
+		Name:          fmt.Sprintf("operation-%d", s.nextID),
+		OperationType: kind,
+		Status:        "RUNNING",
+		TargetLink:    s.selfLink("global/routes/" + route),
+	}
+	if f != nil {
+		op.Error = &compute.OperationError{
+			Errors: []*compute.OperationErrorErrors{{Code: f.OperationError, Message: f.message}},
+		}
+	}
+	if s.OperationPolls == 0 {
+		op.Status = "DONE"
+	}
+	s.operations[op.Name] = &operation{op: op}
+	writeJSON(w, op)
+}
+
+// failure returns the first injected failure matching the request, if any,
+// and uses it up.
+func (s *Server) failure(method, route string) *Failure {
+	for i, f := range s.failures {
+		if (f.Method != "" && f.Method != method) || (f.Route != "" && f.Route != route) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (s *Server) selfLink(path string) string {
+	return fmt.Sprintf("%s%s/%s", s.URL, s.Project, path)
+}
+
+func (s *Server) sortedNetworks() []*compute.Network {
+	names := make([]string, 0, len(s.networks))
+	for name := range s.networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ns := make([]*compute.Network, 0, len(names))
+	for _, name := range names {
+		ns = append(ns, s.networks[name])
+	}
+	return ns
+}
+
+type byName []*compute.Route
+
+func (rs byName) Len() int           { return len(rs) }
+func (rs byName) Less(i, j int) bool { return rs[i].Name < rs[j].Name }
+func (rs byName) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors":  []map[string]string{{"reason": reason, "message": message}},
+		},
+	})
+}
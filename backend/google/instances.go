@@ -0,0 +1,118 @@
+package google
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+)
+
+// instanceRefreshInterval is the minimum time between two listings of the
+// instances caused by unknown addresses.
+var instanceRefreshInterval = 30 * time.Second
+
+// instanceCache maps the internal and external addresses of the instances in
+// the first network to the instances.
+type instanceCache struct {
+	mu        sync.Mutex
+	network   *compute.Network
+	byIP      map[string]*instance
+	refreshed time.Time
+}
+
+// instance is an instance with the self-link and internal address of its
+// interface in each network.
+type instance struct {
+	selfLink   string
+	networkIPs map[string]string
+}
+
+// reset makes the next lookup list the instances again.
+func (c *instanceCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshed = time.Time{}
+}
+
+// nextHop returns the next hop of a route to ip: the self-link of the
+// instance owning ip in instance mode, otherwise ip itself in the first
+// network and the address of the instance owning ip in the others.
+func (rm networkManager) nextHop(ip string) (string, error) {
+	if !rm.nextHopInstance && rm.network == rm.instances.network {
+		return ip, nil
+	}
+	inst, err := rm.instance(ip)
+	if err != nil {
+		return "", err
+	}
+	networkIP, ok := inst.networkIPs[rm.network.SelfLink]
+	if !ok {
+		return "", fmt.Errorf("google: instance %s has no interface in network %s", inst.selfLink, rm.network.Name)
+	}
+	if rm.nextHopInstance {
+		return inst.selfLink, nil
+	}
+	return networkIP, nil
+}
+
+// instance returns the instance owning ip in the first network.
+func (rm networkManager) instance(ip string) (*instance, error) {
+	c := rm.instances
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if inst, ok := c.byIP[ip]; ok && !c.refreshed.IsZero() {
+		return inst, nil
+	}
+	if time.Since(c.refreshed) >= instanceRefreshInterval {
+		byIP, err := rm.listInstances()
+		if err != nil {
+			return nil, err
+		}
+		c.byIP = byIP
+		c.refreshed = time.Now()
+		if inst, ok := c.byIP[ip]; ok {
+			return inst, nil
+		}
+	}
+	return nil, fmt.Errorf("google: no instance found for %s in network %s", ip, c.network.Name)
+}
+
+// listInstances lists the instances of all zones of the instance project, not
+// the host project of a Shared VPC, and maps the addresses of their
+// interfaces in the first network to them.
+func (rm networkManager) listInstances() (map[string]*instance, error) {
+	byIP := make(map[string]*instance)
+	call := rm.computeService.Instances.AggregatedList(rm.instanceProject)
+	for {
+		var list *compute.InstanceAggregatedList
+		err := rm.call(rm.instanceProject, func() (err error) {
+			list, err = call.Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, scoped := range list.Items {
+			for _, i := range scoped.Instances {
+				inst := &instance{selfLink: i.SelfLink, networkIPs: make(map[string]string)}
+				for _, ni := range i.NetworkInterfaces {
+					inst.networkIPs[ni.Network] = ni.NetworkIP
+					if ni.Network != rm.instances.network.SelfLink {
+						continue
+					}
+					byIP[ni.NetworkIP] = inst
+					for _, ac := range ni.AccessConfigs {
+						if ac.NatIP != "" {
+							byIP[ac.NatIP] = inst
+						}
+					}
+				}
+			}
+		}
+		if list.NextPageToken == "" {
+			return byIP, nil
+		}
+		call = rm.computeService.Instances.AggregatedList(rm.instanceProject).PageToken(list.NextPageToken)
+	}
+}
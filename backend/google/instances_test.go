@@ -0,0 +1,61 @@
+package google
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+func TestMultiNetwork(t *testing.T) {
+	for _, nextHopInstance := range []bool{false, true} {
+		t.Run(fmt.Sprintf("instance=%v", nextHopInstance), func(t *testing.T) {
+			fake := newTestServer(t, "prod", "mgmt")
+			fake.AddInstance("us-a", &compute.Instance{Name: "n1", NetworkInterfaces: []*compute.NetworkInterface{
+				{Network: fake.Network("prod"), NetworkIP: "10.0.0.1"},
+				{Network: fake.Network("mgmt"), NetworkIP: "192.168.0.1"},
+			}})
+			fake.AddInstance("us-b", &compute.Instance{Name: "n2", NetworkInterfaces: []*compute.NetworkInterface{
+				{Network: fake.Network("prod"), NetworkIP: "10.0.0.2"},
+			}})
+			rm := newTestRouteManager(t, fake, &Config{Networks: []string{"prod", "mgmt"}, NextHopInstance: nextHopInstance})
+			resp, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.0.0.1", "10.244.2.0/24": "10.0.0.2"})
+			if err == nil || len(resp.Errors) != 1 || resp.Errors[0].Route != "flannel-mgmt-10-244-2-0-24" {
+				t.Errorf("Sync returned %v with errors %v, want n2 missing from mgmt", err, resp.Errors)
+			}
+			n1, n2 := fake.URL+"p/zones/us-a/instances/n1", fake.URL+"p/zones/us-b/instances/n2"
+			want := map[string]string{
+				"flannel-mgmt-10-244-1-0-24": "192.168.0.1",
+				"flannel-prod-10-244-1-0-24": "10.0.0.1",
+				"flannel-prod-10-244-2-0-24": "10.0.0.2",
+			}
+			if nextHopInstance {
+				want = map[string]string{
+					"flannel-mgmt-10-244-1-0-24": n1,
+					"flannel-prod-10-244-1-0-24": n1,
+					"flannel-prod-10-244-2-0-24": n2,
+				}
+			}
+			routes := fake.Routes()
+			if len(routes) != len(want) {
+				t.Errorf("got %d routes, want %d", len(routes), len(want))
+			}
+			for _, r := range routes {
+				network := strings.Split(r.Name, "-")[1]
+				if got := r.NextHopIp + r.NextHopInstance; got != want[r.Name] || r.Network != fake.Network(network) {
+					t.Errorf("route %s goes to %s in %s, want %s in %s", r.Name, got, r.Network, want[r.Name], network)
+				}
+			}
+			name, err := rm.Insert("10.0.0.1", "10.244.3.0/24")
+			if err != nil || name != "flannel-prod-10-244-3-0-24,flannel-mgmt-10-244-3-0-24" {
+				t.Errorf("Insert returned %q, %v", name, err)
+			}
+			deleted, err := rm.DeleteAllRoutes()
+			if err != nil || len(deleted) != 5 || len(fake.Routes()) != 0 {
+				t.Errorf("DeleteAllRoutes deleted %v, %v", deleted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package google
+
+import (
+	"sync"
+	"time"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+)
+
+// inventory caches the routes managed by the route manager, keyed by name.
+// It is updated by the route manager's own operations and listed again when
+// it is older than the refresh interval, or after an operation failed.
+type inventory struct {
+	mu        sync.Mutex
+	routes    map[string]*compute.Route
+	refreshed time.Time
+}
+
+// inserted records the outcome of inserting route.
+func (inv *inventory) inserted(route *compute.Route, err error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err != nil {
+		inv.refreshed = time.Time{}
+		return
+	}
+	if inv.routes != nil {
+		inv.routes[route.Name] = route
+	}
+}
+
+// deleted records the outcome of deleting the route name.
+func (inv *inventory) deleted(name string, err error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err != nil {
+		inv.refreshed = time.Time{}
+		return
+	}
+	delete(inv.routes, name)
+}
+
+// Refresh lists the managed routes again, replacing the inventory.
+func (rm networkManager) Refresh() error {
+	inv := rm.inventory
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return rm.refresh()
+}
+
+// refresh lists the managed routes into the inventory. The inventory lock
+// must be held.
+func (rm networkManager) refresh() error {
+	rs, err := rm.routes()
+	if err != nil {
+		return err
+	}
+	rm.inventory.routes = make(map[string]*compute.Route)
+	for _, r := range rs {
+		rm.inventory.routes[r.Name] = r
+	}
+	rm.inventory.refreshed = time.Now()
+	return nil
+}
+
+// routemap returns the managed routes by name from the inventory, refreshing
+// it first if needed.
+func (rm networkManager) routemap() (map[string]*compute.Route, error) {
+	inv := rm.inventory
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if time.Since(inv.refreshed) >= rm.inventoryRefresh {
+		if err := rm.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	m := make(map[string]*compute.Route, len(inv.routes))
+	for name, r := range inv.routes {
+		m[name] = r
+	}
+	return m, nil
+}
+
+type byName []*compute.Route
+
+func (rs byName) Len() int           { return len(rs) }
+func (rs byName) Less(i, j int) bool { return rs[i].Name < rs[j].Name }
+func (rs byName) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
@@ -0,0 +1,88 @@
+package google
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/google/googletest"
+)
+
+func TestInventory(t *testing.T) {
+	fake := newTestServer(t, "default")
+	rm := newTestRouteManager(t, fake, &Config{})
+	in := backend.RouteTable{"10.244.1.0/24": "10.240.0.2"}
+	if _, err := rm.Sync(in); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rm.Delete("10.244.3.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rm.Sync(in); err != nil {
+		t.Fatal(err)
+	}
+	if n, m := fake.Requests("routes.list"), fake.Requests("routes.insert"); n != 1 || m != 1 || fake.Requests("routes.delete") != 0 {
+		t.Errorf("listed %d times and inserted %d times, want the inventory to answer", n, m)
+	}
+
+	// A failed operation makes the next change list the routes again.
+	fake.Fail(googletest.Failure{Method: "routes.delete", OperationError: "INTERNAL", Times: 1})
+	if _, err := rm.Delete("10.244.1.0/24"); err == nil {
+		t.Fatal("Delete succeeded despite the operation error")
+	}
+	resp, err := rm.Sync(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.Requests("routes.list"); n != 2 || len(resp.Unchanged) != 1 {
+		t.Errorf("listed %d times with %v unchanged, want the surviving route listed again", n, resp.Unchanged)
+	}
+
+	rm = newTestRouteManager(t, fake, &Config{InventoryRefresh: time.Nanosecond})
+	for i := 0; i < 2; i++ {
+		if _, err := rm.Plan(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fake.Requests("routes.list"); n != 4 {
+		t.Errorf("listed %d times, want every stale inventory listed again", n-2)
+	}
+}
+
+func TestListRoutes(t *testing.T) {
+	fake := newTestServer(t, "default", "other")
+	fake.PageSize = 1
+	rm := newTestRouteManager(t, fake, &Config{})
+	for _, r := range []*compute.Route{
+		{Name: "flannel-default-10-244-1-0-24", DestRange: "10.244.1.0/24"},
+		{Name: "flannel-default-10-244-2-0-24", DestRange: "10.244.2.0/24"},
+		{Name: "flannel-default-10-244-3-0-24", DestRange: "10.244.3.0/24", Network: fake.Network("other")},
+		{Name: "flannel-other-10-244-4-0-24", DestRange: "10.244.4.0/24"},
+		{Name: "manual", DestRange: "10.244.5.0/24"},
+	} {
+		r.Description = markerDescription("", r.DestRange)
+		r.NextHopIp = "10.240.0.9"
+		fake.AddRoute(r)
+	}
+	resp, err := rm.Sync(backend.RouteTable{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(resp.Deleted)
+	if want := "flannel-default-10-244-1-0-24 flannel-default-10-244-2-0-24"; strings.Join(resp.Deleted, " ") != want {
+		t.Errorf("Sync deleted %v, want %s", resp.Deleted, want)
+	}
+	// Three routes match the prefix, one per page.
+	if n := fake.Requests("routes.list"); n != 3 {
+		t.Errorf("listed %d pages, want 3", n)
+	}
+	if n := len(fake.Routes()); n != 3 {
+		t.Errorf("%d routes left, want the routes of other networks and prefixes", n)
+	}
+}
@@ -0,0 +1,46 @@
+package google
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"code.google.com/p/goauth2/oauth/jwt"
+	"code.google.com/p/google-api-go-client/compute/v1"
+)
+
+// serviceAccountKey is the JSON key file of a service account as downloaded
+// from the developers console.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	ProjectID   string `json:"project_id"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// clientFromKeyFile returns a client authenticated as the service account
+// of the JSON key file path, and the project the account belongs to.
+func clientFromKeyFile(path string) (*http.Client, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, "", fmt.Errorf("google: parsing key file %s: %v", path, err)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, "", errors.New("google: key file " + path + " is not a service account key")
+	}
+	token := jwt.NewToken(key.ClientEmail, compute.ComputeScope, []byte(key.PrivateKey))
+	if key.TokenURI != "" {
+		token.ClaimSet.Aud = key.TokenURI
+	}
+	transport, err := jwt.NewTransport(token)
+	if err != nil {
+		return nil, "", fmt.Errorf("google: authenticating as %s: %v", key.ClientEmail, err)
+	}
+	return transport.Client(), key.ProjectID, nil
+}
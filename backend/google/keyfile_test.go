@@ -0,0 +1,120 @@
+package google
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+)
+
+// newTestKeyFile writes a service account key file for the token server at
+// tokenURI and returns its path and the public key.
+func newTestKeyFile(t *testing.T, tokenURI string) (string, *rsa.PublicKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&serviceAccountKey{
+		ClientEmail: "rm@p.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		ProjectID:   "p",
+		TokenURI:    tokenURI,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, &key.PublicKey
+}
+
+func TestClientFromKeyFile(t *testing.T) {
+	var public *rsa.PublicKey
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api" {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			}
+			return
+		}
+		// The assertion is a JWT signed with the private key of the key
+		// file, claiming the compute scope.
+		parts := strings.Split(r.PostFormValue("assertion"), ".")
+		if r.PostFormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+			http.Error(w, "invalid grant", http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claimSet struct {
+			Iss   string `json:"iss"`
+			Scope string `json:"scope"`
+		}
+		json.Unmarshal(claims, &claimSet)
+		if rsa.VerifyPKCS1v15(public, crypto.SHA256, sum[:], sig) != nil || claimSet.Iss != "rm@p.iam.gserviceaccount.com" || claimSet.Scope != compute.ComputeScope {
+			http.Error(w, "invalid assertion", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+
+	var path string
+	path, public = newTestKeyFile(t, server.URL+"/token")
+	client, project, err := clientFromKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project != "p" {
+		t.Errorf("got project %q, want p", project)
+	}
+	resp, err := client.Get(server.URL + "/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("authenticated request returned %s", resp.Status)
+	}
+
+	// A key the token server doesn't accept.
+	path, _ = newTestKeyFile(t, server.URL+"/token")
+	if _, _, err := clientFromKeyFile(path); err == nil || !strings.HasPrefix(err.Error(), "google: authenticating as rm@p.iam.gserviceaccount.com: ") {
+		t.Errorf("clientFromKeyFile returned %v, want an authentication error", err)
+	}
+}
+
+func TestInvalidKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"garbage.json": "not json",
+		"user.json":    `{"client_id":"x","client_secret":"y","type":"authorized_user"}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := clientFromKeyFile(path); err == nil || !strings.HasPrefix(err.Error(), "google: ") || !strings.Contains(err.Error(), path) {
+			t.Errorf("clientFromKeyFile(%s) returned %v", name, err)
+		}
+	}
+	if _, _, err := clientFromKeyFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("clientFromKeyFile of a missing file succeeded")
+	}
+}
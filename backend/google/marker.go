@@ -0,0 +1,78 @@
+package google
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+const markerTool = "flannel-route-manager"
+
+// marker is written to the description of every route the route manager
+// creates, to tell its routes from those of other clusters and tools.
+type marker struct {
+	Tool    string `json:"tool"`
+	Cluster string `json:"cluster"`
+	// Subnet is the etcd key of the subnet lease, e.g. 10.244.1.0-24.
+	Subnet string `json:"subnet"`
+	// Index is the etcd index the lease was last modified at, if known.
+	Index uint64 `json:"index,omitempty"`
+}
+
+type ownership int
+
+const (
+	// owned routes carry the marker of this cluster.
+	owned ownership = iota
+	// unmarked routes carry no marker. They were created by hand or by an
+	// older version, and are only touched in adoption mode.
+	unmarked
+	// foreign routes carry the marker of another cluster or tool and are
+	// never touched.
+	foreign
+)
+
+func (rm networkManager) ownership(route *compute.Route) ownership {
+	return routeOwnership(route, rm.clusterID)
+}
+
+// routeOwnership returns the ownership of route by the cluster clusterID.
+func routeOwnership(route *compute.Route, clusterID string) ownership {
+	var m marker
+	if err := json.Unmarshal([]byte(route.Description), &m); err != nil || m.Tool == "" {
+		return unmarked
+	}
+	if m.Tool != markerTool || m.Cluster != clusterID {
+		return foreign
+	}
+	return owned
+}
+
+// manages reports whether the route manager may change route: it is owned,
+// or unmarked and adopted.
+func (rm networkManager) manages(route *compute.Route) bool {
+	switch rm.ownership(route) {
+	case owned:
+		return true
+	case unmarked:
+		return rm.adopt
+	}
+	return false
+}
+
+// description returns the marker of the route to subnet.
+func (rm networkManager) description(subnet string) string {
+	m := marker{
+		Tool:    markerTool,
+		Cluster: rm.clusterID,
+		Subnet:  strings.Replace(subnet, "/", "-", -1),
+	}
+	rm.annotations.mu.Lock()
+	m.Index, _ = strconv.ParseUint(rm.annotations.m[subnet][backend.EtcdIndexAnnotation], 10, 64)
+	rm.annotations.mu.Unlock()
+	data, _ := json.Marshal(m)
+	return string(data)
+}
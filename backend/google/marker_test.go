@@ -0,0 +1,114 @@
+package google
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+// markerDescription returns the description of a route the cluster clusterID
+// owns.
+func markerDescription(clusterID, subnet string) string {
+	data, _ := json.Marshal(marker{Tool: markerTool, Cluster: clusterID, Subnet: strings.Replace(subnet, "/", "-", -1)})
+	return string(data)
+}
+
+func TestRouteOwnership(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		want        ownership
+	}{
+		{markerDescription("prod", "10.244.1.0/24"), owned},
+		{markerDescription("", "10.244.1.0/24"), foreign},
+		{markerDescription("staging", "10.244.1.0/24"), foreign},
+		{`{"tool":"other-tool","cluster":"prod"}`, foreign},
+		{"", unmarked},
+		{"created by hand", unmarked},
+		{`{"cluster":"prod"}`, unmarked},
+	} {
+		if got := routeOwnership(&compute.Route{Description: tt.description}, "prod"); got != tt.want {
+			t.Errorf("ownership of %q is %d, want %d", tt.description, got, tt.want)
+		}
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	const other = `{"tool":"flannel-route-manager","cluster":"other"}`
+	for _, adopt := range []bool{false, true} {
+		fake := newTestServer(t, "default")
+		fake.AddRoute(&compute.Route{Name: "flannel-default-10-244-1-0-24", DestRange: "10.244.1.0/24", NextHopIp: "10.240.0.2", Priority: DefaultPriority})
+		fake.AddRoute(&compute.Route{Name: "flannel-default-10-244-2-0-24", DestRange: "10.244.2.0/24", NextHopIp: "10.240.0.3", Priority: DefaultPriority, Description: other})
+		rm := newTestRouteManager(t, fake, &Config{Adopt: adopt})
+		rm.Annotate("10.244.1.0/24", map[string]string{backend.EtcdIndexAnnotation: "42"})
+		resp, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.2", "10.244.2.0/24": "10.240.0.3"})
+		if err == nil {
+			t.Errorf("adopt=%v: Sync over a foreign route succeeded", adopt)
+		}
+		errs := make(map[string]string)
+		for _, e := range resp.Errors {
+			errs[e.Route] = e.Err.Error()
+		}
+		if e := errs["flannel-default-10-244-2-0-24"]; !strings.HasSuffix(e, " is owned by "+other) {
+			t.Errorf("adopt=%v: Sync returned %q for the foreign route", adopt, e)
+		}
+		unmarked := errs["flannel-default-10-244-1-0-24"]
+		if adopt {
+			if len(resp.Replaced) != 0 || len(resp.Unchanged) != 1 || unmarked != "" {
+				t.Errorf("adopt=true: Sync replaced %v, kept %v with error %q, want the unmarked route adopted as it is", resp.Replaced, resp.Unchanged, unmarked)
+			}
+		} else if !strings.HasSuffix(unmarked, " has no ownership marker, use adoption to take it over") {
+			t.Errorf("adopt=false: Sync returned %q for the unmarked route", unmarked)
+		}
+		routes := fake.Routes()
+		if routes[0].Description != "" {
+			t.Errorf("adopt=%v: unchanged unmarked route got description %q", adopt, routes[0].Description)
+		}
+		if routes[1].Description != other {
+			t.Errorf("foreign route got description %q", routes[1].Description)
+		}
+
+		if adopt {
+			// An adopted route gets the marker when it is replaced.
+			resp, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.4"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Replaced) != 1 {
+				t.Errorf("adopt=true: Sync replaced %v, want the adopted route with a new next hop", resp.Replaced)
+			}
+			var m marker
+			json.Unmarshal([]byte(fake.Routes()[0].Description), &m)
+			if m.Tool != markerTool || m.Subnet != "10.244.1.0-24" || m.Index != 42 {
+				t.Errorf("replaced adopted route has description %q", fake.Routes()[0].Description)
+			}
+		}
+
+		deleted, err := rm.DeleteAllRoutes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if adopt {
+			want = "flannel-default-10-244-1-0-24"
+		}
+		if strings.Join(deleted, " ") != want {
+			t.Errorf("adopt=%v: DeleteAllRoutes deleted %v, want %q", adopt, deleted, want)
+		}
+	}
+}
+
+func TestSyncDropsAnnotations(t *testing.T) {
+	fake := newTestServer(t, "default")
+	rm := newTestRouteManager(t, fake, &Config{})
+	rm.Annotate("10.244.1.0/24", map[string]string{backend.EtcdIndexAnnotation: "1"})
+	rm.Annotate("10.244.2.0/24", map[string]string{backend.EtcdIndexAnnotation: "2"})
+	if _, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rm.annotations.m["10.244.2.0/24"]; ok || len(rm.annotations.m) != 1 {
+		t.Errorf("got annotations %v, want those of the expired lease dropped", rm.annotations.m)
+	}
+}
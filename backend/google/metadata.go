@@ -1,38 +1,221 @@
 package google
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"strconv"
+	"sync"
+	"time"
 )
 
-func networkFromMetadata() (string, error) {
-	network, err := metadataGet("/instance/network-interfaces/0/network")
-	if err != nil {
-		return "", err
+var (
+	// metadataTimeout bounds a single metadata request. The metadata
+	// server answers within milliseconds, so off GCE this is what detection
+	// takes per attempt.
+	metadataTimeout = 2 * time.Second
+	metadataRetries = 3
+	metadataBackoff = 500 * time.Millisecond
+	// metadataWatchTimeout is how long a watch waits for a change before
+	// the metadata server returns the unchanged value.
+	metadataWatchTimeout = 5 * time.Minute
+)
+
+// ErrNotOnGCE is returned when the metadata server can't be reached, or
+// something else answers in its place.
+var ErrNotOnGCE = errors.New("google: not running on GCE: the metadata server is unreachable")
+
+// MetadataClient reads the GCE metadata server. Requests time out, and
+// transport errors and 5xx responses are retried with backoff.
+type MetadataClient struct {
+	endpoint string
+	client   *http.Client
+	watcher  *http.Client
+}
+
+// NewMetadataClient returns a client of the metadata server at endpoint, e.g.
+// a local stand-in. It defaults to the server at $GCE_METADATA_HOST, then to
+// the one of the instance.
+func NewMetadataClient(endpoint string) *MetadataClient {
+	if endpoint == "" {
+		endpoint = metadataEndpoint
+		if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+			endpoint = "http://" + host + "/computeMetadata/v1"
+		}
 	}
-	return path.Base(network), nil
+	return &MetadataClient{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: metadataTimeout},
+		watcher:  &http.Client{Timeout: metadataWatchTimeout + metadataTimeout},
+	}
+}
+
+// Get returns the value at path, e.g. /project/project-id.
+func (c *MetadataClient) Get(path string) (string, error) {
+	value, _, err := c.get(c.client, path, nil)
+	return value, err
+}
+
+// OnGCE reports whether the metadata server can be reached.
+func (c *MetadataClient) OnGCE() bool {
+	_, err := c.Get("/")
+	return err != ErrNotOnGCE
+}
+
+// Watch waits until the value at path no longer has the ETag etag and returns
+// the new value and ETag. After metadataWatchTimeout it returns the unchanged
+// value and etag, so callers watch in a loop. With an empty etag it returns
+// the current value right away.
+func (c *MetadataClient) Watch(path, etag string) (string, string, error) {
+	if etag == "" {
+		return c.get(c.client, path, nil)
+	}
+	query := url.Values{
+		"wait_for_change": {"true"},
+		"last_etag":       {etag},
+		"timeout_sec":     {strconv.Itoa(int(metadataWatchTimeout / time.Second))},
+	}
+	return c.get(c.watcher, path, query)
 }
 
-func projectFromMetadata() (string, error) {
-	return metadataGet("/project/project-id")
+func (c *MetadataClient) get(client *http.Client, path string, query url.Values) (string, string, error) {
+	u := c.endpoint + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	backoff := metadataBackoff
+	for attempt := 0; ; attempt++ {
+		value, etag, err := c.do(client, u, path)
+		if err == nil || !retryableMetadata(err) {
+			return value, etag, err
+		}
+		if attempt >= metadataRetries {
+			if _, ok := err.(*metadataStatusError); ok {
+				return "", "", err
+			}
+			log.Printf("google: metadata server at %s unreachable: %v\n", c.endpoint, err)
+			return "", "", ErrNotOnGCE
+		}
+		log.Printf("google: %v, retrying in %v\n", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
 }
 
-func metadataGet(path string) (string, error) {
-	req, err := http.NewRequest("GET", metadataEndpoint+path, nil)
+func (c *MetadataClient) do(client *http.Client, u, path string) (string, string, error) {
+	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	req.Header.Add("Metadata-Flavor", "Google")
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	defer resp.Body.Close()
+	if resp.Header.Get("Metadata-Flavor") != "Google" {
+		return "", "", ErrNotOnGCE
+	}
 	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", &metadataStatusError{path: path, code: resp.StatusCode, status: resp.Status}
+	}
+	return string(data), resp.Header.Get("ETag"), nil
+}
+
+type metadataStatusError struct {
+	path   string
+	code   int
+	status string
+}
+
+func (e *metadataStatusError) Error() string {
+	return fmt.Sprintf("google: metadata %s: %s", e.path, e.status)
+}
+
+// retryableMetadata reports whether err is worth retrying: transport errors
+// and 429 and 5xx responses.
+func retryableMetadata(err error) bool {
+	if e, ok := err.(*metadataStatusError); ok {
+		return e.code == http.StatusTooManyRequests || e.code >= 500
+	}
+	return err != ErrNotOnGCE && retryable(err)
+}
+
+func (c *MetadataClient) network() (string, error) {
+	network, err := c.nonEmpty("/instance/network-interfaces/0/network")
+	if err != nil {
+		return "", err
+	}
+	return path.Base(network), nil
+}
+
+func (c *MetadataClient) project() (string, error) {
+	return c.nonEmpty("/project/project-id")
+}
+
+func (c *MetadataClient) nonEmpty(path string) (string, error) {
+	value, err := c.Get(path)
+	if err == nil && value == "" {
+		err = fmt.Errorf("google: metadata %s is empty", path)
+	}
+	return value, err
+}
+
+// tokenTransport authenticates requests as the instance service account, with
+// tokens from the metadata server.
+type tokenTransport struct {
+	metadata *MetadataClient
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	// RoundTrip must not modify req.
+	r := *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(&r)
+}
+
+// accessToken returns the current token, fetching a new one a minute before
+// the old one expires.
+func (t *tokenTransport) accessToken() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expiry) {
+		return t.token, nil
+	}
+	data, err := t.metadata.Get("/instance/service-accounts/default/token")
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(data), &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("google: invalid service account token from the metadata server: %q", data)
+	}
+	t.token = token.AccessToken
+	t.expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return t.token, nil
 }
@@ -0,0 +1,291 @@
+package google
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMetadataServer serves values by path like the metadata server, with
+// their ETags and waiting for changes. It fails the first failures requests
+// with 503, and leaves the Metadata-Flavor header out unless flavor is set.
+type testMetadataServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	values   map[string]string
+	failures int
+	flavor   bool
+	delay    time.Duration
+	requests int
+	// query is the query of the last request.
+	query url.Values
+}
+
+func newTestMetadataServer(t *testing.T, values map[string]string) *testMetadataServer {
+	timeout, backoff := metadataTimeout, metadataBackoff
+	metadataTimeout, metadataBackoff = 100*time.Millisecond, time.Millisecond
+	t.Cleanup(func() { metadataTimeout, metadataBackoff = timeout, backoff })
+	s := &testMetadataServer{values: values, flavor: true}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// reset sets the misbehaviour of the server and clears the request count.
+func (s *testMetadataServer) reset(failures int, flavor bool, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures, s.flavor, s.delay, s.requests = failures, flavor, delay, 0
+}
+
+// set changes the value at path.
+func (s *testMetadataServer) set(path, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[path] = value
+}
+
+// lastQuery returns the query of the last request.
+func (s *testMetadataServer) lastQuery() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.query
+}
+
+func metadataETag(value string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(value)))[:16]
+}
+
+// count returns the number of requests since the last reset.
+func (s *testMetadataServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *testMetadataServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	s.query = r.URL.Query()
+	failing := s.failures > 0
+	s.failures--
+	flavor, delay := s.flavor, s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+	value, ok := s.wait(r)
+	if flavor {
+		w.Header().Set("Metadata-Flavor", "Google")
+	}
+	switch {
+	case r.Header.Get("Metadata-Flavor") != "Google":
+		http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+	case failing:
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	case !ok:
+		http.NotFound(w, r)
+	default:
+		w.Header().Set("ETag", metadataETag(value))
+		w.Write([]byte(value))
+	}
+}
+
+// wait returns the value at the path of r, once its ETag differs from
+// last_etag or timeout_sec passed if r waits for a change.
+func (s *testMetadataServer) wait(r *http.Request) (string, bool) {
+	q := r.URL.Query()
+	timeout, _ := strconv.Atoi(q.Get("timeout_sec"))
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		s.mu.Lock()
+		value, ok := s.values[r.URL.Path]
+		s.mu.Unlock()
+		if q.Get("wait_for_change") != "true" || metadataETag(value) != q.Get("last_etag") || time.Now().After(deadline) {
+			return value, ok
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetadataGet(t *testing.T) {
+	s := newTestMetadataServer(t, map[string]string{
+		"/computeMetadata/v1/":                                      "",
+		"/computeMetadata/v1/project/project-id":                    "p",
+		"/computeMetadata/v1/instance/network-interfaces/0/network": "projects/1/networks/default",
+	})
+	c := NewMetadataClient(s.URL + "/computeMetadata/v1")
+	if !c.OnGCE() {
+		t.Error("OnGCE = false")
+	}
+	if project, err := c.project(); err != nil || project != "p" {
+		t.Errorf("project() = %q, %v", project, err)
+	}
+	if network, err := c.network(); err != nil || network != "default" {
+		t.Errorf("network() = %q, %v", network, err)
+	}
+	_, err := c.Get("/instance/attributes/missing")
+	if e, ok := err.(*metadataStatusError); !ok || e.code != http.StatusNotFound {
+		t.Errorf("Get of a missing value returned %v, want a 404", err)
+	}
+	if _, err := c.nonEmpty("/"); err == nil || err.Error() != "google: metadata / is empty" {
+		t.Errorf("nonEmpty returned %v", err)
+	}
+}
+
+func TestMetadataRetry(t *testing.T) {
+	s := newTestMetadataServer(t, map[string]string{"/project/project-id": "p"})
+	c := NewMetadataClient(s.URL)
+	s.reset(metadataRetries, true, 0)
+	if project, err := c.Get("/project/project-id"); err != nil || project != "p" {
+		t.Errorf("Get = %q, %v", project, err)
+	}
+	if n := s.count(); n != metadataRetries+1 {
+		t.Errorf("made %d requests, want %d", n, metadataRetries+1)
+	}
+
+	// Status errors are returned as they are once the retries run out.
+	s.reset(metadataRetries+1, true, 0)
+	_, err := c.Get("/project/project-id")
+	if e, ok := err.(*metadataStatusError); !ok || e.code != http.StatusServiceUnavailable || s.count() != metadataRetries+1 {
+		t.Errorf("Get returned %v after %d requests, want a 503", err, s.count())
+	}
+}
+
+func TestMetadataWatch(t *testing.T) {
+	watchTimeout := metadataWatchTimeout
+	metadataWatchTimeout = time.Second
+	t.Cleanup(func() { metadataWatchTimeout = watchTimeout })
+	s := newTestMetadataServer(t, map[string]string{"/instance/attributes/flannel-tags": "a"})
+	// The client waits a request timeout longer than the server.
+	metadataTimeout = time.Second
+	c := NewMetadataClient(s.URL)
+
+	// Without an ETag the current value comes back right away.
+	value, etag, err := c.Watch("/instance/attributes/flannel-tags", "")
+	if err != nil || value != "a" || etag != metadataETag("a") {
+		t.Fatalf("Watch = %q, %q, %v", value, etag, err)
+	}
+	if q := s.lastQuery(); len(q) != 0 {
+		t.Errorf("first Watch sent query %v", q)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.set("/instance/attributes/flannel-tags", "b")
+	}()
+	value, newETag, err := c.Watch("/instance/attributes/flannel-tags", etag)
+	if err != nil || value != "b" || newETag != metadataETag("b") {
+		t.Errorf("Watch = %q, %q, %v, want the changed value", value, newETag, err)
+	}
+	want := url.Values{"wait_for_change": {"true"}, "last_etag": {etag}, "timeout_sec": {"1"}}
+	if q := s.lastQuery(); q.Encode() != want.Encode() {
+		t.Errorf("Watch sent query %v, want %v", q, want)
+	}
+
+	// Without a change the server answers with the unchanged value after
+	// timeout_sec, before the client gives up.
+	start := time.Now()
+	value, etag, err = c.Watch("/instance/attributes/flannel-tags", newETag)
+	if err != nil || value != "b" || etag != newETag {
+		t.Errorf("Watch = %q, %q, %v, want the unchanged value", value, etag, err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("Watch returned after %v, want it to wait for the server", d)
+	}
+}
+
+func TestNotOnGCE(t *testing.T) {
+	// Something other than a metadata server answers.
+	s := newTestMetadataServer(t, map[string]string{"/project/project-id": "p"})
+	s.reset(0, false, 0)
+	c := NewMetadataClient(s.URL)
+	if _, err := c.Get("/project/project-id"); err != ErrNotOnGCE || s.count() != 1 {
+		t.Errorf("Get returned %v after %d requests, want ErrNotOnGCE right away", err, s.count())
+	}
+	if c.OnGCE() {
+		t.Error("OnGCE = true without the Metadata-Flavor header")
+	}
+
+	// The metadata server doesn't answer in time.
+	s.reset(0, true, 2*metadataTimeout)
+	start := time.Now()
+	if _, err := c.Get("/project/project-id"); err != ErrNotOnGCE || s.count() != metadataRetries+1 {
+		t.Errorf("Get returned %v after %d requests, want ErrNotOnGCE after the retries", err, s.count())
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Get took %v", d)
+	}
+
+	// No server at all.
+	s.Close()
+	if c.OnGCE() {
+		t.Error("OnGCE = true without a metadata server")
+	}
+	if _, err := New(&Config{MetadataEndpoint: s.URL}); err != ErrNotOnGCE {
+		t.Errorf("New returned %v, want ErrNotOnGCE", err)
+	}
+}
+
+func TestNewFromMetadata(t *testing.T) {
+	fake := newTestServer(t, "default")
+	s := newTestMetadataServer(t, map[string]string{
+		"/project/project-id":                    "p",
+		"/instance/network-interfaces/0/network": "projects/1/networks/default",
+	})
+	rm, err := New(&Config{Client: http.DefaultClient, Endpoint: fake.URL, MetadataEndpoint: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := rm.networks[0]; n.project != "p" || n.network.Name != "default" {
+		t.Errorf("got project %s and network %s", n.project, n.network.Name)
+	}
+}
+
+func TestTokenTransport(t *testing.T) {
+	const path = "/instance/service-accounts/default/token"
+	s := newTestMetadataServer(t, map[string]string{path: `{"access_token":"t1","expires_in":3600,"token_type":"Bearer"}`})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	t.Cleanup(api.Close)
+	transport := &tokenTransport{metadata: NewMetadataClient(s.URL)}
+	client := &http.Client{Transport: transport}
+	get := func() string {
+		resp, err := client.Get(api.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return string(data)
+	}
+	if got := get() + " " + get(); got != "Bearer t1 Bearer t1" || s.count() != 1 {
+		t.Errorf("sent %q with %d token requests, want the token fetched once", got, s.count())
+	}
+
+	// Tokens are fetched again a minute before they expire.
+	s.set(path, `{"access_token":"t2","expires_in":30}`)
+	transport.expiry = time.Now()
+	if got := get(); got != "Bearer t2" {
+		t.Errorf("sent %q, want the new token", got)
+	}
+	if got := get(); got != "Bearer t2" || s.count() != 3 {
+		t.Errorf("sent %q after %d token requests, want a token that is about to expire fetched again", got, s.count())
+	}
+
+	s.set(path, "<html>")
+	transport.expiry = time.Now()
+	if _, err := client.Get(api.URL); err == nil {
+		t.Error("request with an invalid token succeeded")
+	}
+	s.Close()
+	if _, err := New(&Config{MetadataEndpoint: s.URL, Networks: []string{"default"}, Project: "p"}); err != ErrNotOnGCE {
+		t.Errorf("New returned %v, want ErrNotOnGCE", err)
+	}
+}
@@ -0,0 +1,129 @@
+package google
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+// MigrateRouteNames renames the routes named after the cluster ID
+// fromClusterID to the names of the route manager's cluster ID. Each route is
+// migrated without a gap: the route under the new name is inserted and
+// verified before the old route is deleted. Routes already migrated are
+// reported unchanged, so an interrupted migration can be run again. With
+// dryRun nothing is changed.
+func (rm *RouteManager) MigrateRouteNames(fromClusterID string, dryRun bool) (*backend.SyncResponse, error) {
+	response := backend.NewSyncResponse()
+	var lastError error
+	for _, n := range rm.networks {
+		r, err := n.migrateRouteNames(fromClusterID, dryRun)
+		if err != nil {
+			lastError = err
+		}
+		response.Merge(r)
+	}
+	return response, lastError
+}
+
+func (rm networkManager) migrateRouteNames(fromClusterID string, dryRun bool) (*backend.SyncResponse, error) {
+	response := backend.NewSyncResponse()
+	oldPrefix := routePrefix(fromClusterID, rm.network.Name)
+	if oldPrefix == rm.prefix {
+		return response, nil
+	}
+	old, err := rm.listRoutes(oldPrefix, func(route *compute.Route) bool {
+		if route.Name != formatRouteName(oldPrefix, route.DestRange) {
+			return false
+		}
+		switch routeOwnership(route, fromClusterID) {
+		case owned:
+			return true
+		case unmarked:
+			return rm.adopt
+		}
+		return false
+	})
+	if err != nil {
+		return response, err
+	}
+	sort.Sort(byName(old))
+	for _, o := range old {
+		route := rm.migratedRoute(o)
+		existing, err := rm.get(route.Name)
+		if err != nil {
+			response.Errors = append(response.Errors, &backend.RouteError{Route: o.Name, Err: err})
+			continue
+		}
+		switch {
+		case existing != nil && sameRoute(existing, route):
+			response.Unchanged = append(response.Unchanged, route.Name)
+		case dryRun:
+			response.Inserted = append(response.Inserted, route.Name)
+		default:
+			if err := rm.upsert(route); err != nil {
+				response.Errors = append(response.Errors, &backend.RouteError{Route: route.Name, Err: err})
+				continue
+			}
+			if err := rm.verify(o, route); err != nil {
+				response.Errors = append(response.Errors, &backend.RouteError{Route: route.Name, Err: err})
+				continue
+			}
+			response.Inserted = append(response.Inserted, route.Name)
+		}
+		if !dryRun {
+			if err := rm.delete(o.Name); err != nil {
+				response.Errors = append(response.Errors, &backend.RouteError{Route: o.Name, Err: err})
+				continue
+			}
+		}
+		response.Deleted = append(response.Deleted, o.Name)
+	}
+	if len(response.Errors) > 0 {
+		return response, fmt.Errorf("google: %d routes failed to migrate", len(response.Errors))
+	}
+	return response, nil
+}
+
+// migratedRoute returns old under its new name, with the marker of the route
+// manager's cluster.
+func (rm networkManager) migratedRoute(old *compute.Route) *compute.Route {
+	return &compute.Route{
+		Name:            rm.routeName(old.DestRange),
+		Description:     rm.description(old.DestRange),
+		DestRange:       old.DestRange,
+		Network:         old.Network,
+		NextHopInstance: old.NextHopInstance,
+		NextHopIp:       old.NextHopIp,
+		Priority:        old.Priority,
+		Tags:            old.Tags,
+	}
+}
+
+// verify checks that route was inserted as intended, and that GCE has no
+// warnings about it that it had none about for old.
+func (rm networkManager) verify(old, route *compute.Route) error {
+	got, err := rm.get(route.Name)
+	if err != nil {
+		return err
+	}
+	if got == nil || !sameRoute(got, route) {
+		return fmt.Errorf("google: route %s differs after insert, keeping %s", route.Name, old.Name)
+	}
+	if len(got.Warnings) > 0 && len(old.Warnings) == 0 {
+		return fmt.Errorf("google: route %s has warning %s, keeping %s", route.Name, got.Warnings[0].Code, old.Name)
+	}
+	return nil
+}
+
+// sameRoute reports whether a and b route the same destination the same way.
+func sameRoute(a, b *compute.Route) bool {
+	key := func(r *compute.Route) string {
+		tags := append([]string{}, r.Tags...)
+		sort.Strings(tags)
+		return fmt.Sprintf("%s %s %s %s %d %s", r.DestRange, r.Network, r.NextHopIp, r.NextHopInstance, r.Priority, strings.Join(tags, ","))
+	}
+	return key(a) == key(b)
+}
@@ -0,0 +1,73 @@
+package google
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/google/googletest"
+)
+
+func TestMigrateRouteNames(t *testing.T) {
+	fake := newTestServer(t, "default")
+	table := backend.RouteTable{"10.244.1.0/24": "10.240.0.2", "10.244.2.0/24": "10.240.0.3", "10.244.3.0/24": "10.240.0.4"}
+	if _, err := newTestRouteManager(t, fake, &Config{}).Sync(table); err != nil {
+		t.Fatal(err)
+	}
+	// Unmarked routes are only migrated in adoption mode.
+	fake.AddRoute(&compute.Route{Name: "flannel-default-10-244-9-0-24", DestRange: "10.244.9.0/24", NextHopIp: "10.240.0.9", Priority: DefaultPriority})
+	rm := newTestRouteManager(t, fake, &Config{ClusterID: "prod"})
+	names := func(prefix string) []string {
+		return []string{prefix + "10-244-1-0-24", prefix + "10-244-2-0-24", prefix + "10-244-3-0-24"}
+	}
+	oldNames, newNames := names("flannel-default-"), names("flannel-334928204007-")
+
+	resp, err := rm.MigrateRouteNames("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(resp.Inserted) != fmt.Sprint(newNames) || fmt.Sprint(resp.Deleted) != fmt.Sprint(oldNames) {
+		t.Errorf("dry run inserts %v and deletes %v", resp.Inserted, resp.Deleted)
+	}
+	if n := len(fake.Routes()); n != 4 || fake.Requests("routes.insert") != 3 {
+		t.Errorf("dry run changed the routes to %d", n)
+	}
+
+	fake.Fail(googletest.Failure{Method: "routes.delete", Route: oldNames[1], Code: 400, Times: 1})
+	resp, err = rm.MigrateRouteNames("", false)
+	if err == nil || err.Error() != "google: 1 routes failed to migrate" {
+		t.Errorf("MigrateRouteNames returned %v", err)
+	}
+	if len(resp.Inserted) != 3 || len(resp.Deleted) != 2 || len(resp.Errors) != 1 || resp.Errors[0].Route != oldNames[1] {
+		t.Errorf("MigrateRouteNames inserted %v and deleted %v with errors %v", resp.Inserted, resp.Deleted, resp.Errors)
+	}
+
+	// Run again, the interrupted route is finished.
+	resp, err = rm.MigrateRouteNames("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(resp.Unchanged) != fmt.Sprint(newNames[1:2]) || fmt.Sprint(resp.Deleted) != fmt.Sprint(oldNames[1:2]) || len(resp.Inserted) != 0 {
+		t.Errorf("second run inserts %v, deletes %v and keeps %v", resp.Inserted, resp.Deleted, resp.Unchanged)
+	}
+	var got []string
+	for _, r := range fake.Routes() {
+		got = append(got, r.Name)
+		var m marker
+		if json.Unmarshal([]byte(r.Description), &m); r.DestRange != "10.244.9.0/24" && m.Cluster != "prod" {
+			t.Errorf("route %s has description %q", r.Name, r.Description)
+		}
+	}
+	want := append([]string{"flannel-default-10-244-9-0-24"}, newNames...)
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got routes %v, want %v", got, want)
+	}
+	resp, err = rm.Sync(table)
+	if err != nil || len(resp.Unchanged) != 3 {
+		t.Errorf("Sync after the migration returned %v, %v unchanged", err, resp.Unchanged)
+	}
+}
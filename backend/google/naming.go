@@ -0,0 +1,44 @@
+package google
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxNameLength is the longest name GCE accepts for a route.
+const maxNameLength = 63
+
+// maxPrefixLength leaves room in a route name for the longest subnet,
+// 255-255-255-255-32.
+const maxPrefixLength = maxNameLength - len("255-255-255-255-32")
+
+var replacer = strings.NewReplacer(".", "-", "/", "-")
+
+var clusterIDPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// routePrefix returns the prefix of the names of all routes owned by the
+// route manager of the cluster clusterID in network: flannel-<network>-
+// without a cluster ID, and flannel-<hash>- with one, where the hash of the
+// cluster ID and network is 12 digits. Network names start with a letter,
+// so the two forms never overlap, unlike flannel-<cluster>-<network>-,
+// which is the same for cluster a in network b-c and for no cluster in
+// network a-b-c. A network name too long to fit a subnet is hashed too.
+func routePrefix(clusterID, network string) string {
+	prefix := fmt.Sprintf("flannel-%s-", network)
+	if clusterID != "" || len(prefix) > maxPrefixLength {
+		sum := sha256.Sum256([]byte(clusterID + "/" + network))
+		prefix = fmt.Sprintf("flannel-%012d-", binary.BigEndian.Uint64(sum[:8])%1e12)
+	}
+	return prefix
+}
+
+func formatRouteName(prefix, subnet string) string {
+	return prefix + replacer.Replace(subnet)
+}
+
+func (rm networkManager) routeName(subnet string) string {
+	return formatRouteName(rm.prefix, subnet)
+}
@@ -0,0 +1,58 @@
+package google
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+func TestRoutePrefix(t *testing.T) {
+	for _, tt := range []struct {
+		clusterID, network, want string
+	}{
+		{"", "default", "flannel-default-"},
+		{"prod", "default", "flannel-334928204007-"},
+		// Cluster a in network b-c must not get the prefix of network
+		// a-b-c without a cluster.
+		{"a", "b-c", "flannel-120750069121-"},
+		{"", "a-b-c", "flannel-a-b-c-"},
+		{"", strings.Repeat("n", 63), "flannel-066617347374-"},
+	} {
+		if got := routePrefix(tt.clusterID, tt.network); got != tt.want {
+			t.Errorf("routePrefix(%q, %q) = %q, want %q", tt.clusterID, tt.network, got, tt.want)
+		}
+	}
+
+	long := strings.Repeat("c", 40)
+	prefix := routePrefix(long, "default")
+	if len(prefix) > maxPrefixLength || !strings.HasPrefix(prefix, "flannel-") {
+		t.Errorf("routePrefix of a long cluster ID is %q", prefix)
+	}
+	if routePrefix(long, "default") != prefix {
+		t.Error("hashed prefix changed between calls")
+	}
+	if routePrefix(long, "other") == prefix || routePrefix(long+"x", "default") == prefix {
+		t.Error("hashed prefixes of different clusters or networks are equal")
+	}
+	if name := formatRouteName(prefix, "255.255.255.255/32"); len(name) > maxNameLength {
+		t.Errorf("route name %s is longer than %d", name, maxNameLength)
+	}
+}
+
+func TestLongClusterID(t *testing.T) {
+	fake := newTestServer(t, "default")
+	rm := newTestRouteManager(t, fake, &Config{ClusterID: strings.Repeat("c", 60)})
+	if _, err := rm.Sync(backend.RouteTable{"255.255.255.255/32": "10.240.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	routes := fake.Routes()
+	if len(routes) != 1 || len(routes[0].Name) > maxNameLength {
+		t.Errorf("got routes %v, want one with a name of at most %d characters", routes, maxNameLength)
+	}
+	for _, id := range []string{"Prod", "prod_1", "-prod", "prod-"} {
+		if _, err := New(&Config{Client: rm.networks[0].client, Endpoint: fake.URL, Networks: []string{"default"}, Project: "p", ClusterID: id}); err == nil {
+			t.Errorf("New accepted cluster ID %q", id)
+		}
+	}
+}
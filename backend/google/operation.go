@@ -0,0 +1,60 @@
+package google
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+)
+
+var operationPollInterval = time.Second
+
+// wait polls the global operation op until it is done and returns the error
+// it finished with, if any.
+func (rm networkManager) wait(op *compute.Operation) error {
+	var err error
+	deadline := time.Now().Add(rm.operationTimeout)
+	for op.Status != "DONE" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("google: timed out waiting for operation %s", op.Name)
+		}
+		time.Sleep(operationPollInterval)
+		name := op.Name
+		err = rm.call(rm.project, func() (err error) {
+			op, err = rm.computeService.GlobalOperations.Get(rm.project, name).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+	return &OperationError{Operation: op.Name, Errors: op.Error.Errors}
+}
+
+// OperationError is the error a compute operation finished with.
+type OperationError struct {
+	Operation string
+	Errors    []*compute.OperationErrorErrors
+}
+
+func (e *OperationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, oe := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", oe.Code, oe.Message))
+	}
+	return fmt.Sprintf("google: operation %s failed: %s", e.Operation, strings.Join(messages, "; "))
+}
+
+// hasCode reports whether the operation failed with the given error code.
+func (e *OperationError) hasCode(code string) bool {
+	for _, oe := range e.Errors {
+		if oe.Code == code {
+			return true
+		}
+	}
+	return false
+}
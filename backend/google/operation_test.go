@@ -0,0 +1,42 @@
+package google
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/google/googletest"
+)
+
+func TestOperationError(t *testing.T) {
+	fake := newTestServer(t, "default")
+	fake.OperationPolls = 2
+	rm := newTestRouteManager(t, fake, &Config{})
+	fake.Fail(googletest.Failure{Method: "routes.insert", Route: "flannel-default-10-244-1-0-24", OperationError: "QUOTA_EXCEEDED", Times: 1})
+	in := backend.RouteTable{"10.244.1.0/24": "10.240.0.2", "10.244.2.0/24": "10.240.0.3"}
+	resp, err := rm.Sync(in)
+	if err == nil || err.Error() != "google: 1 routes failed to sync" {
+		t.Errorf("Sync returned %v", err)
+	}
+	if len(resp.Errors) != 1 || len(resp.Inserted) != 1 {
+		t.Fatalf("Sync inserted %v with errors %v", resp.Inserted, resp.Errors)
+	}
+	e, ok := resp.Errors[0].Err.(*OperationError)
+	if !ok || !e.hasCode("QUOTA_EXCEEDED") {
+		t.Errorf("Sync returned %#v, want an operation error with code QUOTA_EXCEEDED", resp.Errors[0].Err)
+	}
+	resp, err = rm.Sync(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Inserted) != 1 || resp.Inserted[0] != "flannel-default-10-244-1-0-24" || len(resp.Unchanged) != 1 {
+		t.Errorf("second Sync inserted %v, want the failed route", resp.Inserted)
+	}
+
+	fake.OperationPolls = 1000
+	rm = newTestRouteManager(t, fake, &Config{OperationTimeout: 20 * time.Millisecond})
+	if _, err := rm.Insert("10.240.0.4", "10.244.4.0/24"); err == nil || !strings.HasPrefix(err.Error(), "google: timed out waiting for operation ") {
+		t.Errorf("Insert returned %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,110 @@
+package google
+
+import (
+	"sort"
+	"sync"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+)
+
+// routeJob changes the routes to a single destination. Its deletes finish
+// before the insert starts; routes to different destinations are changed by
+// separate jobs in parallel.
+type routeJob struct {
+	dest    string
+	deletes []*compute.Route
+	// replaced is the route that insert replaces. It has the same name, so
+	// it is deleted after deletes and before insert, and the destination
+	// is unrouted until insert finishes.
+	replaced *compute.Route
+	insert   *compute.Route
+
+	deleteErrs []error
+	insertErr  error
+}
+
+func (j *routeJob) run(rm networkManager) {
+	j.deleteErrs = make([]error, len(j.deletes))
+	for i, route := range j.deletes {
+		j.deleteErrs[i] = rm.delete(route.Name)
+	}
+	if j.replaced != nil {
+		// Routes can't be updated, so a new next hop means delete and
+		// insert. If the insert fails the destination stays unrouted
+		// until the next sync.
+		if j.insertErr = rm.delete(j.replaced.Name); j.insertErr != nil {
+			return
+		}
+	}
+	if j.insert != nil {
+		j.insertErr = rm.upsert(j.insert)
+	}
+}
+
+// jobs groups the changes of p by destination. Jobs that only delete come
+// first, so that they free quota before the inserts, then jobs are ordered by
+// destination.
+func (p *routePlan) jobs() []*routeJob {
+	byDest := make(map[string]*routeJob)
+	job := func(dest string) *routeJob {
+		j, ok := byDest[dest]
+		if !ok {
+			j = &routeJob{dest: dest}
+			byDest[dest] = j
+		}
+		return j
+	}
+	for _, route := range p.stray {
+		j := job(route.DestRange)
+		j.deletes = append(j.deletes, route)
+	}
+	for _, subnet := range p.Delete {
+		j := job(subnet)
+		j.deletes = append(j.deletes, p.current[subnet])
+	}
+	for _, subnet := range p.Replace {
+		j := job(subnet)
+		j.replaced, j.insert = p.current[subnet], p.desired[subnet]
+	}
+	for _, subnet := range p.Insert {
+		job(subnet).insert = p.desired[subnet]
+	}
+	jobs := make([]*routeJob, 0, len(byDest))
+	for _, j := range byDest {
+		jobs = append(jobs, j)
+	}
+	sort.Sort(byDeletesFirst(jobs))
+	return jobs
+}
+
+// runJobs runs jobs on a pool of rm.concurrency workers, starting them in
+// order.
+func (rm networkManager) runJobs(jobs []*routeJob) {
+	queue := make(chan *routeJob)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(rm.sem) && i < len(jobs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				j.run(rm)
+			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+}
+
+type byDeletesFirst []*routeJob
+
+func (js byDeletesFirst) Len() int      { return len(js) }
+func (js byDeletesFirst) Swap(i, j int) { js[i], js[j] = js[j], js[i] }
+func (js byDeletesFirst) Less(i, j int) bool {
+	if a, b := js[i].insert == nil, js[j].insert == nil; a != b {
+		return a
+	}
+	return js[i].dest < js[j].dest
+}
@@ -0,0 +1,83 @@
+package google
+
+import (
+	"fmt"
+	"testing"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+func TestJobs(t *testing.T) {
+	route := func(name, dest string) *compute.Route {
+		return &compute.Route{Name: name, DestRange: dest}
+	}
+	p := &routePlan{
+		Changes: &backend.Changes{
+			Delete:  []string{"10.244.3.0/24"},
+			Insert:  []string{"10.244.1.0/24", "10.244.0.0/24"},
+			Replace: []string{"10.244.2.0/24"},
+		},
+		stray: []*compute.Route{route("stale", "10.244.1.0/24")},
+		current: map[string]*compute.Route{
+			"10.244.2.0/24": route("r2", "10.244.2.0/24"),
+			"10.244.3.0/24": route("r3", "10.244.3.0/24"),
+		},
+		desired: map[string]*compute.Route{
+			"10.244.0.0/24": route("r0", "10.244.0.0/24"),
+			"10.244.1.0/24": route("r1", "10.244.1.0/24"),
+			"10.244.2.0/24": route("r2", "10.244.2.0/24"),
+		},
+	}
+	var got []string
+	for _, j := range p.jobs() {
+		s := j.dest
+		for _, r := range j.deletes {
+			s += " -" + r.Name
+		}
+		if j.replaced != nil {
+			s += " ~" + j.replaced.Name
+		}
+		if j.insert != nil {
+			s += " +" + j.insert.Name
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"10.244.3.0/24 -r3",
+		"10.244.0.0/24 +r0",
+		"10.244.1.0/24 -stale +r1",
+		"10.244.2.0/24 ~r2 +r2",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got jobs %q, want %q", got, want)
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	fake := newTestServer(t, "default")
+	fake.OperationPolls = 1
+	rm := newTestRouteManager(t, fake, &Config{Concurrency: 3})
+	in := make(backend.RouteTable)
+	for i := 0; i < 20; i++ {
+		in[fmt.Sprintf("10.244.%d.0/24", i)] = "10.240.0.2"
+	}
+	if _, err := rm.Sync(in); err != nil {
+		t.Fatal(err)
+	}
+	for subnet := range in {
+		in[subnet] = "10.240.0.3"
+	}
+	resp, err := rm.Sync(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Replaced) != 20 {
+		t.Errorf("replaced %d routes, want 20", len(resp.Replaced))
+	}
+	for subnet, ip := range routeTable(fake) {
+		if ip != "10.240.0.3" {
+			t.Errorf("route to %s goes to %s", subnet, ip)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package google
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+// routesQuota returns the ROUTES quota of the project and its usage. ok is
+// false when the project has no such quota.
+func (rm networkManager) routesQuota() (limit, usage int, ok bool, err error) {
+	var project *compute.Project
+	err = rm.call(rm.project, func() (err error) {
+		project, err = rm.computeService.Projects.Get(rm.project).Do()
+		return err
+	})
+	if err != nil {
+		return 0, 0, false, err
+	}
+	for _, q := range project.Quotas {
+		if q.Metric == "ROUTES" {
+			return int(q.Limit), int(q.Usage), true, nil
+		}
+	}
+	return 0, 0, false, nil
+}
+
+// quota is the part of the ROUTES quota of the project left for inserts. The
+// networks of a route manager are planned one after another against the same
+// quota, so that together they stay within it.
+type quota struct {
+	ok        bool
+	limit     int
+	usage     int
+	available int
+}
+
+// quota reads the ROUTES quota of the project.
+func (rm *RouteManager) quota() (*quota, error) {
+	limit, usage, ok, err := rm.networks[0].routesQuota()
+	if err != nil {
+		return nil, err
+	}
+	return &quota{ok: ok, limit: limit, usage: usage, available: limit - usage}, nil
+}
+
+// limitInserts drops the inserts of p that would exceed q, counting the
+// routes p deletes first, and takes the rest from q. The inserts that fit are
+// chosen by route priority, then by subnet; the others are reported as errors
+// and left unrouted.
+func (rm networkManager) limitInserts(p *routePlan, q *quota) {
+	if !q.ok {
+		return
+	}
+	q.available += len(p.stray) + len(p.Delete)
+	if q.available >= len(p.Insert) {
+		q.available -= len(p.Insert)
+		return
+	}
+	fit := q.available
+	if fit < 0 {
+		fit = 0
+	}
+	log.Printf("google: routes quota: %d of %d used, %d of %d new routes fit in network %s\n", q.usage, q.limit, fit, len(p.Insert), rm.network.Name)
+	inserts := make([]*compute.Route, len(p.Insert))
+	for i, subnet := range p.Insert {
+		inserts[i] = p.desired[subnet]
+	}
+	sort.Sort(byPriority(inserts))
+	for _, route := range inserts[fit:] {
+		err := fmt.Errorf("google: ROUTES quota exhausted (%d of %d used), %s not routed", q.usage, q.limit, route.DestRange)
+		p.errors = append(p.errors, &backend.RouteError{Route: route.Name, Err: err})
+	}
+	p.Insert = make([]string, fit)
+	for i, route := range inserts[:fit] {
+		p.Insert[i] = route.DestRange
+	}
+	q.available -= fit
+}
+
+type byPriority []*compute.Route
+
+func (rs byPriority) Len() int      { return len(rs) }
+func (rs byPriority) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+func (rs byPriority) Less(i, j int) bool {
+	if rs[i].Priority != rs[j].Priority {
+		return rs[i].Priority < rs[j].Priority
+	}
+	return rs[i].DestRange < rs[j].DestRange
+}
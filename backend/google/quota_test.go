@@ -0,0 +1,70 @@
+package google
+
+import (
+	"strings"
+	"testing"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+func TestQuota(t *testing.T) {
+	fake := newTestServer(t, "default")
+	fake.RoutesQuota = 3
+	fake.AddRoute(&compute.Route{Name: "manual", DestRange: "192.168.0.0/24", NextHopIp: "10.240.0.9"})
+	rm := newTestRouteManager(t, fake, &Config{})
+	if _, err := rm.Insert("10.240.0.9", "10.244.9.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	rm.Annotate("10.244.3.0/24", map[string]string{PriorityAnnotation: "10"})
+	// Deleting 10.244.9.0/24 frees one route for the two that fit: the
+	// lowest priority value first, then the lowest subnet.
+	in := backend.RouteTable{"10.244.1.0/24": "10.240.0.2", "10.244.2.0/24": "10.240.0.3", "10.244.3.0/24": "10.240.0.4"}
+	plan, err := rm.Plan(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rm.Sync(in)
+	if err == nil {
+		t.Error("Sync beyond the quota succeeded")
+	}
+	for _, r := range []*backend.SyncResponse{plan, resp} {
+		if len(r.Errors) != 1 || r.Errors[0].Route != "flannel-default-10-244-2-0-24" || !strings.Contains(r.Errors[0].Err.Error(), "ROUTES quota exhausted (2 of 3 used)") {
+			t.Errorf("got errors %v, want 10.244.2.0/24 left out", r.Errors)
+		}
+		if len(r.Inserted) != 2 || len(r.Deleted) != 1 {
+			t.Errorf("got inserts %v and deletes %v", r.Inserted, r.Deleted)
+		}
+	}
+	table := routeTable(fake)
+	if _, ok := table["10.244.2.0/24"]; ok || len(table) != 3 {
+		t.Errorf("got routes %v", table)
+	}
+}
+
+func TestQuotaSharedByNetworks(t *testing.T) {
+	fake := newTestServer(t, "prod", "mgmt")
+	fake.RoutesQuota = 3
+	fake.AddInstance("us-a", &compute.Instance{Name: "n1", NetworkInterfaces: []*compute.NetworkInterface{
+		{Network: fake.Network("prod"), NetworkIP: "10.0.0.1"},
+		{Network: fake.Network("mgmt"), NetworkIP: "192.168.0.1"},
+	}})
+	rm := newTestRouteManager(t, fake, &Config{Networks: []string{"prod", "mgmt"}})
+	in := backend.RouteTable{"10.244.1.0/24": "10.0.0.1", "10.244.2.0/24": "10.0.0.1"}
+	plan, err := rm.Plan(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rm.Sync(in)
+	if err == nil {
+		t.Error("Sync beyond the quota succeeded")
+	}
+	for _, r := range []*backend.SyncResponse{plan, resp} {
+		if len(r.Inserted) != 3 || len(r.Errors) != 1 || r.Errors[0].Route != "flannel-mgmt-10-244-2-0-24" {
+			t.Errorf("got inserts %v and errors %v, want the last route of mgmt left out", r.Inserted, r.Errors)
+		}
+	}
+	if n := len(fake.Routes()); n != 3 {
+		t.Errorf("got %d routes, want 3", n)
+	}
+}
@@ -0,0 +1,102 @@
+package google
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"code.google.com/p/google-api-go-client/googleapi"
+)
+
+var retryBackoff = time.Second
+
+// call makes the API request fn to project, waiting for the rate limiter
+// first. Retryable errors are retried with jittered exponential backoff.
+// Permission errors name the project.
+func (rm networkManager) call(project string, fn func() error) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		rm.limiter.wait()
+		err := fn()
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusForbidden && !retryable(err) {
+			return fmt.Errorf("google: permission denied in %s: %v", rm.describeProject(project), err)
+		}
+		if err == nil || !retryable(err) || attempt >= rm.retries {
+			return err
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("google: %v, retrying in %v\n", err, wait)
+		time.Sleep(wait)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (rm networkManager) describeProject(project string) string {
+	if project == rm.project && rm.project != rm.instanceProject {
+		return "Shared VPC host project " + project
+	}
+	return "project " + project
+}
+
+// retryable reports whether err is worth retrying: transport errors, 429 and
+// 5xx responses, and rate limit errors.
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *googleapi.Error:
+		if e.Code == http.StatusTooManyRequests || e.Code >= 500 {
+			return true
+		}
+		for _, item := range e.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+		return false
+	case *url.Error, net.Error:
+		return true
+	}
+	return false
+}
+
+// rateLimiter is a token bucket allowing rate requests per second on
+// average, in bursts of up to burst requests.
+type rateLimiter struct {
+	mu     sync.Mutex
+	burst  float64
+	last   time.Time
+	rate   float64
+	tokens float64
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{burst: burst, last: time.Now(), rate: rate, tokens: burst}
+}
+
+// wait blocks until a request may be made.
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens < 0 {
+		// Sleeping with the lock held queues the other requests behind
+		// this one.
+		time.Sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,95 @@
+package google
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/google-api-go-client/googleapi"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/google/googletest"
+)
+
+func TestRetryable(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, false},
+		{&googleapi.Error{Code: http.StatusBadRequest}, false},
+		{&url.Error{Op: "Get", URL: "http://compute", Err: errors.New("connection refused")}, true},
+		{errors.New("other"), false},
+	} {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	fake := newTestServer(t, "default")
+	rm := newTestRouteManager(t, fake, &Config{Retries: 2})
+	fake.Fail(googletest.Failure{Method: "routes.list", Code: http.StatusServiceUnavailable, Times: 1})
+	fake.Fail(googletest.Failure{Method: "routes.insert", Code: http.StatusTooManyRequests, Times: 2})
+	if _, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, m := fake.Requests("routes.list"), fake.Requests("routes.insert"); n != 2 || m != 3 {
+		t.Errorf("listed %d and inserted %d times, want 2 and 3", n, m)
+	}
+
+	// Retries run out.
+	fake.Fail(googletest.Failure{Method: "routes.delete", Code: http.StatusInternalServerError})
+	if _, err := rm.Delete("10.244.1.0/24"); err == nil {
+		t.Error("Delete succeeded despite the server errors")
+	}
+	if n := fake.Requests("routes.delete"); n != 3 {
+		t.Errorf("deleted %d times, want 3", n)
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	fake := newTestServer(t, "default")
+	rm := newTestRouteManager(t, fake, &Config{Retries: 2})
+	fake.Fail(googletest.Failure{Method: "routes.insert", Code: http.StatusBadRequest, Times: 1})
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err == nil {
+		t.Error("Insert succeeded despite the bad request")
+	}
+	if n := fake.Requests("routes.insert"); n != 1 {
+		t.Errorf("inserted %d times, want 1", n)
+	}
+
+	fake.Fail(googletest.Failure{Method: "routes.insert", Code: http.StatusForbidden})
+	_, err := rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if err == nil || !strings.HasPrefix(err.Error(), "google: permission denied in project p: ") {
+		t.Errorf("Insert returned %v, want permission denied", err)
+	}
+	rm.networks[0].instanceProject = "service"
+	_, err = rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if err == nil || !strings.HasPrefix(err.Error(), "google: permission denied in Shared VPC host project p: ") {
+		t.Errorf("Insert returned %v, want permission denied in the host project", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(20)
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		l.wait()
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("a burst of 20 took %v", d)
+	}
+	for i := 0; i < 10; i++ {
+		l.wait()
+	}
+	if d := time.Since(start); d < 450*time.Millisecond || d > 2*time.Second {
+		t.Errorf("30 requests at 20 per second took %v, want about 500ms", d)
+	}
+}
@@ -1,170 +1,691 @@
 package google
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/kelseyhightower/flannel-route-manager/backend"
 
-	"code.google.com/p/goauth2/compute/serviceaccount"
 	"code.google.com/p/google-api-go-client/compute/v1"
+	"code.google.com/p/google-api-go-client/googleapi"
 )
 
 var metadataEndpoint = "http://169.254.169.254/computeMetadata/v1"
 
-var replacer = strings.NewReplacer(".", "-", "/", "-")
+const (
+	DefaultConcurrency      = 10
+	DefaultInventoryRefresh = 5 * time.Minute
+	DefaultOperationTimeout = 2 * time.Minute
+	DefaultPriority         = 1000
+	// DefaultRequestsPerSecond stays well below the default Compute API
+	// rate limit of 20 requests per second.
+	DefaultRequestsPerSecond = 10
+	DefaultRetries           = 5
+)
+
+type Config struct {
+	// Adopt makes the route manager take over routes named like its own
+	// that carry no ownership marker, e.g. those created by hand or by an
+	// older version.
+	Adopt bool
+	// Client makes the API requests instead of a client authenticated as
+	// the instance service account.
+	Client *http.Client
+	// ClusterID is hashed into the route names, so that several clusters
+	// can share a network.
+	ClusterID string
+	// Concurrency limits the route operations in flight at once.
+	Concurrency int
+	// Endpoint overrides the Compute API base URL, e.g. for a fake.
+	Endpoint string
+	// HostProject is the Shared VPC host project the network and routes
+	// belong to, if it differs from Project.
+	HostProject string
+	// InventoryRefresh is the age at which the cached inventory of routes
+	// is listed again.
+	InventoryRefresh time.Duration
+	// KeyFile is a service account JSON key file to authenticate with
+	// instead of the instance service account.
+	KeyFile string
+	// MetadataEndpoint overrides the metadata server base URL, e.g. for a
+	// local stand-in.
+	MetadataEndpoint string
+	// Networks lists the networks every route is written into. They default
+	// to the network of the instance's first interface. Next hop addresses
+	// belong to the first network; routes in the others go to the address of
+	// the same instance in that network.
+	Networks []string
+	// NextHopInstance makes routes point at the instance owning the next
+	// hop address instead of the address itself.
+	NextHopInstance bool
+	// OperationTimeout bounds the wait for a route operation to finish.
+	OperationTimeout time.Duration
+	// Priority and Tags are set on every route unless the subnet lease
+	// overrides them. Priority defaults to DefaultPriority when nil. Without
+	// tags a route applies to all instances.
+	Priority *int64
+	Tags     []string
+	// RequestsPerSecond limits the rate of API requests. Retries is the
+	// number of times requests failing with rate limit or server errors
+	// are retried.
+	RequestsPerSecond float64
+	Retries           int
+	// Project is the project of the instances. It defaults to the project
+	// of the key file, then to that of the instance. With Networks and Project
+	// set the metadata server is not used.
+	Project string
+}
 
+// RouteManager syncs the routes of every network it was configured with.
 type RouteManager struct {
-	computeService *compute.Service
-	network        *compute.Network
-	project        string
+	annotations *annotationStore
+	instances   *instanceCache
+	networks    []networkManager
 }
 
-func New() (*RouteManager, error) {
-	client, err := serviceaccount.NewClient(&serviceaccount.Options{})
-	if err != nil {
-		return nil, err
+// networkManager manages the routes of a single network. The networks of a
+// route manager share its client, rate limit, worker pool and caches.
+type networkManager struct {
+	adopt            bool
+	annotations      *annotationStore
+	client           *http.Client
+	clusterID        string
+	computeService   *compute.Service
+	instanceProject  string
+	instances        *instanceCache
+	inventory        *inventory
+	inventoryRefresh time.Duration
+	limiter          *rateLimiter
+	network          *compute.Network
+	nextHopInstance  bool
+	operationTimeout time.Duration
+	prefix           string
+	priority         int64
+	project          string
+	retries          int
+	sem              chan struct{}
+	tags             []string
+}
+
+func New(config *Config) (*RouteManager, error) {
+	if config.ClusterID != "" && !clusterIDPattern.MatchString(config.ClusterID) {
+		return nil, fmt.Errorf("google: invalid cluster ID %q, must be lowercase letters, digits and dashes", config.ClusterID)
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	operationTimeout := config.OperationTimeout
+	if operationTimeout <= 0 {
+		operationTimeout = DefaultOperationTimeout
+	}
+	priority := int64(DefaultPriority)
+	if config.Priority != nil {
+		priority = *config.Priority
+	}
+	if priority < 0 || priority > 65535 {
+		return nil, fmt.Errorf("google: invalid priority %d, must be between 0 and 65535", priority)
+	}
+	tags := config.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	inventoryRefresh := config.InventoryRefresh
+	if inventoryRefresh <= 0 {
+		inventoryRefresh = DefaultInventoryRefresh
+	}
+	requestsPerSecond := config.RequestsPerSecond
+	if requestsPerSecond <= 0 {
+		requestsPerSecond = DefaultRequestsPerSecond
+	}
+	retries := config.Retries
+	if retries < 0 {
+		retries = 0
+	}
+	metadata := NewMetadataClient(config.MetadataEndpoint)
+	client, project := config.Client, config.Project
+	if client == nil && config.KeyFile != "" {
+		var keyProject string
+		var err error
+		client, keyProject, err = clientFromKeyFile(config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if project == "" {
+			project = keyProject
+		}
+	}
+	if client == nil {
+		// Tokens of the instance service account come from the metadata
+		// server, with its timeouts and retries.
+		transport := &tokenTransport{metadata: metadata}
+		if _, err := transport.accessToken(); err != nil {
+			return nil, err
+		}
+		client = &http.Client{Transport: transport}
 	}
 	computeService, err := compute.New(client)
 	if err != nil {
 		return nil, err
 	}
-	networkName, err := networkFromMetadata()
-	if err != nil {
-		return nil, err
+	if config.Endpoint != "" {
+		computeService.BasePath = strings.TrimSuffix(config.Endpoint, "/") + "/"
 	}
-	project, err := projectFromMetadata()
-	if err != nil {
-		return nil, err
+	networkNames := config.Networks
+	if len(networkNames) == 0 {
+		networkName, err := metadata.network()
+		if err != nil {
+			return nil, err
+		}
+		networkNames = []string{networkName}
 	}
-	network, err := computeService.Networks.Get(project, networkName).Do()
-	if err != nil {
-		return nil, err
+	if project == "" {
+		project, err = metadata.project()
+		if err != nil {
+			return nil, err
+		}
+	}
+	hostProject := config.HostProject
+	if hostProject == "" {
+		hostProject = project
 	}
 	rm := &RouteManager{
-		computeService: computeService,
-		network:        network,
-		project:        project,
+		annotations: &annotationStore{m: make(map[string]map[string]string)},
+		instances:   &instanceCache{},
 	}
+	base := networkManager{
+		adopt:            config.Adopt,
+		annotations:      rm.annotations,
+		clusterID:        config.ClusterID,
+		client:           client,
+		computeService:   computeService,
+		instances:        rm.instances,
+		inventoryRefresh: inventoryRefresh,
+		limiter:          newRateLimiter(requestsPerSecond),
+		nextHopInstance:  config.NextHopInstance,
+		operationTimeout: operationTimeout,
+		priority:         priority,
+		instanceProject:  project,
+		project:          hostProject,
+		retries:          retries,
+		sem:              make(chan struct{}, concurrency),
+		tags:             tags,
+	}
+	for _, networkName := range networkNames {
+		n := base
+		n.inventory = &inventory{}
+		err = n.call(n.project, func() (err error) {
+			n.network, err = computeService.Networks.Get(hostProject, networkName).Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		n.prefix = routePrefix(config.ClusterID, n.network.Name)
+		rm.networks = append(rm.networks, n)
+	}
+	rm.instances.network = rm.networks[0].network
 	return rm, nil
 }
 
-func (rm RouteManager) Delete(subnet string) (string, error) {
-	name := formatRouteName(rm.network.Name, subnet)
-	err := rm.delete(name)
-	return name, err
+func (rm *RouteManager) Annotate(subnet string, annotations map[string]string) {
+	s := rm.annotations
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(annotations) == 0 {
+		delete(s.m, subnet)
+		return
+	}
+	s.m[subnet] = annotations
+}
+
+// Delete deletes the route to subnet from every network. The route names are
+// joined by commas.
+func (rm *RouteManager) Delete(subnet string) (string, error) {
+	names := make([]string, 0, len(rm.networks))
+	var lastError error
+	for _, n := range rm.networks {
+		name, err := n.Delete(subnet)
+		if err != nil {
+			lastError = err
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ","), lastError
+}
+
+func (rm *RouteManager) DeleteAllRoutes() ([]string, error) {
+	deleted := []string{}
+	var lastError error
+	for _, n := range rm.networks {
+		names, err := n.DeleteAllRoutes()
+		if err != nil {
+			lastError = err
+		}
+		deleted = append(deleted, names...)
+	}
+	return deleted, lastError
+}
+
+// Insert inserts the route to subnet into every network. The route names are
+// joined by commas.
+func (rm *RouteManager) Insert(ip, subnet string) (string, error) {
+	names := make([]string, 0, len(rm.networks))
+	var lastError error
+	for _, n := range rm.networks {
+		name, err := n.Insert(ip, subnet)
+		if err != nil {
+			lastError = err
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ","), lastError
+}
+
+func (rm *RouteManager) Plan(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	response := backend.NewSyncResponse()
+	rm.instances.reset()
+	q, err := rm.quota()
+	if err != nil {
+		return response, err
+	}
+	for _, n := range rm.networks {
+		p, err := n.plan(routes, q)
+		if err != nil {
+			return backend.NewSyncResponse(), err
+		}
+		response.Merge(n.response(p))
+	}
+	return response, nil
+}
+
+// Refresh lists the managed routes of every network again.
+func (rm *RouteManager) Refresh() error {
+	for _, n := range rm.networks {
+		if err := n.Refresh(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync syncs the networks one after another. The ROUTES quota is read once,
+// and each network plans against the part the ones before left. Annotations
+// of subnets not in routes are dropped.
+func (rm *RouteManager) Sync(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	response := backend.NewSyncResponse()
+	var lastError error
+	rm.annotations.retain(routes)
+	rm.instances.reset()
+	q, err := rm.quota()
+	if err != nil {
+		return response, err
+	}
+	for _, n := range rm.networks {
+		r, err := n.sync(routes, q)
+		if err != nil {
+			lastError = err
+		}
+		response.Merge(r)
+	}
+	return response, lastError
+}
+
+// Delete deletes the route to subnet unless the inventory shows it is gone
+// or not managed by the route manager.
+func (rm networkManager) Delete(subnet string) (string, error) {
+	name := rm.routeName(subnet)
+	routes, err := rm.routemap()
+	if err != nil {
+		return name, err
+	}
+	if _, ok := routes[name]; !ok {
+		return name, nil
+	}
+	return name, rm.delete(name)
 }
 
-func (rm RouteManager) DeleteAllRoutes() ([]string, error) {
+func (rm networkManager) DeleteAllRoutes() ([]string, error) {
 	deleted := []string{}
 	var lastError error
-	rs, err := rm.routes()
+	if err := rm.Refresh(); err != nil {
+		return deleted, err
+	}
+	routes, err := rm.routemap()
 	if err != nil {
 		return deleted, err
 	}
+	rs := make([]*compute.Route, 0, len(routes))
+	for _, r := range routes {
+		rs = append(rs, r)
+	}
+	sort.Sort(byName(rs))
+	jobs := make([]*routeJob, 0, len(rs))
 	for _, r := range rs {
-		if err := rm.delete(r.Name); err != nil {
-			lastError = err
+		jobs = append(jobs, &routeJob{dest: r.DestRange, deletes: []*compute.Route{r}})
+	}
+	rm.runJobs(jobs)
+	for _, j := range jobs {
+		if j.deleteErrs[0] != nil {
+			lastError = j.deleteErrs[0]
 		}
-		deleted = append(deleted, r.Name)
+		deleted = append(deleted, j.deletes[0].Name)
 	}
 	return deleted, lastError
 }
 
-func (rm RouteManager) Insert(ip, subnet string) (string, error) {
-	name := formatRouteName(rm.network.Name, subnet)
-	return name, rm.insert(ip, subnet, name)
+// Insert inserts the route to subnet unless the inventory shows it is up to
+// date.
+func (rm networkManager) Insert(ip, subnet string) (string, error) {
+	name := rm.routeName(subnet)
+	nextHop, err := rm.nextHop(ip)
+	if err != nil {
+		return name, err
+	}
+	route := rm.newRoute(nextHop, subnet)
+	routes, err := rm.routemap()
+	if err != nil {
+		return name, err
+	}
+	if existing, ok := routes[name]; ok && rm.upToDate(existing, route) {
+		return name, nil
+	}
+	return name, rm.upsert(route)
 }
 
-func (rm RouteManager) Sync(routes map[string]string) (*backend.SyncResponse, error) {
-	return rm.sync(routes)
+// delete removes the route name and waits for the operation to finish.
+// Routes that are already gone are not an error.
+func (rm networkManager) delete(name string) (err error) {
+	defer func() { rm.inventory.deleted(name, err) }()
+	rm.sem <- struct{}{}
+	defer func() { <-rm.sem }()
+	var op *compute.Operation
+	err = rm.call(rm.project, func() (err error) {
+		op, err = rm.computeService.Routes.Delete(rm.project, name).Do()
+		return err
+	})
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = rm.wait(op)
+	if e, ok := err.(*OperationError); ok && e.hasCode("RESOURCE_NOT_FOUND") {
+		return nil
+	}
+	return err
 }
 
-func (rm RouteManager) delete(name string) error {
-	_, err := rm.computeService.Routes.Delete(rm.project, name).Do()
-	return err
+// insert creates route and waits for the operation to finish.
+func (rm networkManager) insert(route *compute.Route) (err error) {
+	defer func() { rm.inventory.inserted(route, err) }()
+	rm.sem <- struct{}{}
+	defer func() { <-rm.sem }()
+	var op *compute.Operation
+	err = rm.call(rm.project, func() (err error) {
+		op, err = rm.insertRoute(route)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return rm.wait(op)
 }
 
-func (rm RouteManager) insert(ip, subnet, name string) error {
-	route := &compute.Route{
-		Name:      name,
-		DestRange: subnet,
-		Network:   rm.network.SelfLink,
-		NextHopIp: ip,
-		Priority:  1000,
-		Tags:      []string{},
-	}
-	_, err := rm.computeService.Routes.Insert(rm.project, route).Do()
-	return err
+// routeBody is a route as sent to routes.insert. The client library omits a
+// zero priority, which GCE then replaces with its default of 1000, so the
+// priority is always sent.
+type routeBody struct {
+	*compute.Route
+	Priority int64 `json:"priority"`
 }
 
-func (rm RouteManager) sync(in map[string]string) (*backend.SyncResponse, error) {
-	response := &backend.SyncResponse{
-		Inserted: []string{},
-		Deleted:  []string{},
+// insertRoute is Routes.Insert(rm.project, route).Do() of the client
+// library, sending the priority even when it is zero.
+func (rm networkManager) insertRoute(route *compute.Route) (*compute.Operation, error) {
+	body, err := json.Marshal(&routeBody{Route: route, Priority: route.Priority})
+	if err != nil {
+		return nil, err
 	}
-	existing := make(map[string]bool)
-	routemap, err := rm.routemap()
+	u := rm.computeService.BasePath + url.PathEscape(rm.project) + "/global/routes?alt=json"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := rm.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer googleapi.CloseBody(resp)
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+	var op *compute.Operation
+	if err := json.NewDecoder(resp.Body).Decode(&op); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// upsert inserts route, replacing an existing route of the same name unless
+// it is already up to date. This also covers inserts that were retried after
+// they had succeeded.
+func (rm networkManager) upsert(route *compute.Route) error {
+	err := rm.insert(route)
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusConflict {
+		return err
+	}
+	existing, err := rm.get(route.Name)
+	if err != nil || existing == nil {
+		return err
+	}
+	if !rm.manages(existing) {
+		return rm.notManagedError(existing)
+	}
+	if rm.upToDate(existing, route) {
+		return nil
+	}
+	if err := rm.delete(route.Name); err != nil {
+		return err
+	}
+	return rm.insert(route)
+}
+
+// upToDate reports whether existing needs no change to match route. The
+// marker is not compared, so adopted routes keep having none until they
+// need replacing anyway.
+func (rm networkManager) upToDate(existing, route *compute.Route) bool {
+	return existing.DestRange == route.DestRange && rm.state(existing) == rm.state(route)
+}
+
+// get returns the route name, or nil if there is none.
+func (rm networkManager) get(name string) (*compute.Route, error) {
+	var route *compute.Route
+	err := rm.call(rm.project, func() (err error) {
+		route, err = rm.computeService.Routes.Get(rm.project, name).Do()
+		return err
+	})
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	return route, err
+}
+
+func (rm networkManager) notManagedError(route *compute.Route) error {
+	if rm.ownership(route) == unmarked {
+		return fmt.Errorf("google: route %s has no ownership marker, use adoption to take it over", route.Name)
+	}
+	return fmt.Errorf("google: route %s is owned by %s", route.Name, route.Description)
+}
+
+// sync applies the plan for in within q, running the changes to different
+// destinations in parallel. Routes that fail are reported in the response
+// errors.
+func (rm networkManager) sync(in backend.RouteTable, q *quota) (*backend.SyncResponse, error) {
+	response := backend.NewSyncResponse()
+	p, err := rm.plan(in, q)
 	if err != nil {
 		return response, err
 	}
-	for _, route := range routemap {
-		subnet, ok := in[route.NextHopIp]
-		if !ok || subnet != route.DestRange {
-			if err := rm.delete(route.Name); err != nil {
-				return response, err
+	response.Errors = append(response.Errors, p.errors...)
+	jobs := p.jobs()
+	rm.runJobs(jobs)
+	for _, j := range jobs {
+		for i, route := range j.deletes {
+			if j.deleteErrs[i] != nil {
+				response.Errors = append(response.Errors, &backend.RouteError{Route: route.Name, Err: j.deleteErrs[i]})
+				continue
 			}
 			response.Deleted = append(response.Deleted, route.Name)
-			continue
 		}
-		existing[route.NextHopIp] = true
-	}
-	for ip, subnet := range in {
-		if !existing[ip] {
-			name := formatRouteName(rm.network.Name, subnet)
-			if err := rm.insert(ip, subnet, name); err != nil {
-				return response, err
-			}
-			response.Inserted = append(response.Inserted, name)
+		switch {
+		case j.insert == nil:
+		case j.insertErr != nil:
+			response.Errors = append(response.Errors, &backend.RouteError{Route: j.insert.Name, Err: j.insertErr})
+		case j.replaced != nil:
+			response.Replaced = append(response.Replaced, j.insert.Name)
+		default:
+			response.Inserted = append(response.Inserted, j.insert.Name)
 		}
 	}
+	for _, subnet := range p.Unchanged {
+		response.Unchanged = append(response.Unchanged, rm.routeName(subnet))
+	}
+	if len(response.Errors) > 0 {
+		return response, fmt.Errorf("google: %d routes failed to sync", len(response.Errors))
+	}
 	return response, nil
 }
 
-func (rm RouteManager) routemap() (map[string]*compute.Route, error) {
-	m := make(map[string]*compute.Route)
-	routes, err := rm.routes()
+// routePlan is the diff between the managed routes and a flannel route
+// table, keyed by destination subnet.
+type routePlan struct {
+	*backend.Changes
+	// stray holds the managed routes not named after their destination.
+	stray   []*compute.Route
+	current map[string]*compute.Route
+	desired map[string]*compute.Route
+	// errors holds the routes whose next hop could not be resolved, and
+	// those left out for lack of quota. They are left as they are.
+	errors []*backend.RouteError
+}
+
+func (rm networkManager) response(p *routePlan) *backend.SyncResponse {
+	response := backend.NewSyncResponse()
+	for _, route := range p.stray {
+		response.Deleted = append(response.Deleted, route.Name)
+	}
+	response.Merge(p.Response(rm.routeName))
+	response.Errors = append(response.Errors, p.errors...)
+	return response
+}
+
+// plan compares the owned routes with in, keyed by destination subnet,
+// without changing anything. Owned routes not named after their destination
+// are left over from elsewhere and always deleted. Routes whose next hop,
+// priority or tags differ are replaced, including those with the other kind
+// of next hop. Inserts beyond the quota q are left out.
+func (rm networkManager) plan(in backend.RouteTable, q *quota) (*routePlan, error) {
+	p := &routePlan{
+		current: make(map[string]*compute.Route),
+		desired: make(map[string]*compute.Route),
+	}
+	routemap, err := rm.routemap()
 	if err != nil {
 		return nil, err
 	}
-	for _, r := range routes {
-		m[r.Name] = r
+	currentTable := make(backend.RouteTable)
+	for _, route := range routemap {
+		if route.Name != rm.routeName(route.DestRange) {
+			p.stray = append(p.stray, route)
+			continue
+		}
+		p.current[route.DestRange] = route
+		currentTable[route.DestRange] = rm.state(route)
+	}
+	desired := make(backend.RouteTable)
+	for subnet, ip := range in {
+		nextHop, err := rm.nextHop(ip)
+		if err != nil {
+			p.errors = append(p.errors, &backend.RouteError{Route: rm.routeName(subnet), Err: err})
+			if hop, ok := currentTable[subnet]; ok {
+				desired[subnet] = hop
+			}
+			continue
+		}
+		p.desired[subnet] = rm.newRoute(nextHop, subnet)
+		desired[subnet] = rm.state(p.desired[subnet])
+	}
+	p.Changes = backend.Diff(desired, currentTable)
+	rm.limitInserts(p, q)
+	return p, nil
+}
+
+// newRoute returns the route to subnet via nextHop, an address or an
+// instance self-link depending on the next hop mode.
+func (rm networkManager) newRoute(nextHop, subnet string) *compute.Route {
+	priority, tags := rm.settings(subnet)
+	route := &compute.Route{
+		Name:        rm.routeName(subnet),
+		Description: rm.description(subnet),
+		DestRange:   subnet,
+		Network:     rm.network.SelfLink,
+		NextHopIp:   nextHop,
+		Priority:    priority,
+		Tags:        tags,
+	}
+	if rm.nextHopInstance {
+		route.NextHopIp = ""
+		route.NextHopInstance = nextHop
 	}
-	return m, nil
+	return route
 }
 
-func (rm RouteManager) routes() ([]*compute.Route, error) {
+// routes returns the routes managed by the route manager: those in the
+// network whose name starts with the route prefix and that carry its marker,
+// or no marker in adoption mode.
+func (rm networkManager) routes() ([]*compute.Route, error) {
+	return rm.listRoutes(rm.prefix, rm.manages)
+}
+
+// listRoutes returns the routes in the network whose name starts with prefix
+// and for which keep returns true.
+func (rm networkManager) listRoutes(prefix string, keep func(*compute.Route) bool) ([]*compute.Route, error) {
 	rs := make([]*compute.Route, 0)
-	filter := fmt.Sprintf("name eq flannel-%s-.*", rm.network.Name)
-	routeList, err := rm.computeService.Routes.List(rm.project).Filter(filter).Do()
+	filter := fmt.Sprintf("name eq %s.*", prefix)
+	var routeList *compute.RouteList
+	err := rm.call(rm.project, func() (err error) {
+		routeList, err = rm.computeService.Routes.List(rm.project).Filter(filter).Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	for {
 		for _, r := range routeList.Items {
-			rs = append(rs, r)
+			if r.Network == rm.network.SelfLink && keep(r) {
+				rs = append(rs, r)
+			}
 		}
 		if routeList.NextPageToken == "" {
 			break
 		}
-		routeList, err = rm.computeService.Routes.List(rm.project).PageToken(routeList.NextPageToken).Do()
+		pageToken := routeList.NextPageToken
+		err = rm.call(rm.project, func() (err error) {
+			routeList, err = rm.computeService.Routes.List(rm.project).Filter(filter).PageToken(pageToken).Do()
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
 	}
 	return rs, nil
 }
-
-func formatRouteName(network, subnet string) string {
-	return fmt.Sprintf("flannel-%s-%s", network, replacer.Replace(subnet))
-}
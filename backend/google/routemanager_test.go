@@ -0,0 +1,150 @@
+package google
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/backendtest"
+	"github.com/kelseyhightower/flannel-route-manager/backend/google/googletest"
+)
+
+// newTestServer returns a fake of project p with the networks, closed when
+// the test ends. Retries and operation polls wait a millisecond meanwhile.
+func newTestServer(t *testing.T, networks ...string) *googletest.Server {
+	backoff, interval := retryBackoff, operationPollInterval
+	retryBackoff, operationPollInterval = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { retryBackoff, operationPollInterval = backoff, interval })
+	fake := googletest.NewServer("p", networks...)
+	fake.OperationPolls = 0
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// newTestRouteManager returns a route manager on fake, of project p unless
+// the config names another. Unset networks default to default, and the rate limit is raised.
+func newTestRouteManager(t *testing.T, fake *googletest.Server, config *Config) *RouteManager {
+	config.Client = http.DefaultClient
+	config.Endpoint = fake.URL
+	if config.Project == "" {
+		config.Project = "p"
+	}
+	if config.Networks == nil {
+		config.Networks = []string{"default"}
+	}
+	if config.OperationTimeout == 0 {
+		config.OperationTimeout = 5 * time.Second
+	}
+	if config.RequestsPerSecond == 0 {
+		config.RequestsPerSecond = 1000
+	}
+	rm, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+// routeTable returns the routes of fake as a map of destination to next hop.
+func routeTable(fake *googletest.Server) map[string]string {
+	m := make(map[string]string)
+	for _, r := range fake.Routes() {
+		m[r.DestRange] = r.NextHopIp
+	}
+	return m
+}
+
+func TestConformance(t *testing.T) {
+	var fake *googletest.Server
+	backendtest.Run(t, &backendtest.Harness{
+		New: func(t *testing.T) backend.RouteManager {
+			fake = newTestServer(t, "default")
+			fake.PageSize = 1
+			return newTestRouteManager(t, fake, &Config{})
+		},
+		Routes: func(t *testing.T) map[string]string {
+			return routeTable(fake)
+		},
+		AddForeignRoute: func(t *testing.T, ip, subnet string) {
+			fake.AddRoute(&compute.Route{Name: "manual", DestRange: subnet, NextHopIp: ip})
+		},
+	})
+}
+
+func TestPriority(t *testing.T) {
+	zero, invalid := int64(0), int64(65536)
+	for _, tt := range []struct {
+		name       string
+		priority   *int64
+		annotation string
+		want       int64
+	}{
+		{"default", nil, "", DefaultPriority},
+		{"zero", &zero, "", 0},
+		{"annotated zero", nil, "0", 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newTestServer(t, "default")
+			rm := newTestRouteManager(t, fake, &Config{Priority: tt.priority})
+			if tt.annotation != "" {
+				rm.Annotate("10.244.1.0/24", map[string]string{PriorityAnnotation: tt.annotation})
+			}
+			in := backend.RouteTable{"10.244.1.0/24": "10.240.0.2"}
+			if _, err := rm.Sync(in); err != nil {
+				t.Fatal(err)
+			}
+			var priorities []int64
+			for _, r := range fake.Routes() {
+				priorities = append(priorities, r.Priority)
+			}
+			if len(priorities) != 1 || priorities[0] != tt.want {
+				t.Fatalf("got routes with priorities %v, want one with priority %d", priorities, tt.want)
+			}
+			resp, err := rm.Sync(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Unchanged) != 1 {
+				t.Errorf("second Sync replaced %v, want the route unchanged", resp.Replaced)
+			}
+		})
+	}
+	fake := newTestServer(t, "default")
+	if _, err := New(&Config{Client: http.DefaultClient, Endpoint: fake.URL, Networks: []string{"default"}, Project: "p", Priority: &invalid}); err == nil {
+		t.Error("New accepted priority 65536")
+	}
+}
+
+func TestSharedVPC(t *testing.T) {
+	fake := newTestServer(t, "shared")
+	fake.AddServiceInstance("svc", "us-a", &compute.Instance{Name: "n1", NetworkInterfaces: []*compute.NetworkInterface{
+		{Network: fake.Network("shared"), NetworkIP: "10.0.0.1"},
+	}})
+	// The fake serves networks, routes, operations and the quota only in
+	// the host project p, and instances only in the service project svc.
+	rm := newTestRouteManager(t, fake, &Config{HostProject: "p", Networks: []string{"shared"}, NextHopInstance: true, Project: "svc"})
+	if _, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	routes := fake.Routes()
+	if want := fake.URL + "svc/zones/us-a/instances/n1"; len(routes) != 1 || routes[0].NextHopInstance != want {
+		t.Errorf("got routes %v, want one to %s", routes, want)
+	}
+	if fake.Requests("projects.get") != 1 {
+		t.Errorf("read the quota %d times, want once", fake.Requests("projects.get"))
+	}
+
+	fake.Fail(googletest.Failure{Method: "routes.insert", Code: http.StatusForbidden})
+	_, err := rm.Insert("10.0.0.1", "10.244.2.0/24")
+	if err == nil || !strings.HasPrefix(err.Error(), "google: permission denied in Shared VPC host project p: ") {
+		t.Errorf("Insert returned %v, want permission denied in the host project", err)
+	}
+	fake.Fail(googletest.Failure{Method: "instances.aggregatedList", Code: http.StatusForbidden})
+	resp, _ := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.0.0.1"})
+	if len(resp.Errors) != 1 || !strings.HasPrefix(resp.Errors[0].Err.Error(), "google: permission denied in project svc: ") {
+		t.Errorf("Sync returned errors %v, want permission denied in the service project", resp.Errors)
+	}
+}
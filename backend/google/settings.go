@@ -0,0 +1,75 @@
+package google
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.google.com/p/google-api-go-client/compute/v1"
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+// Annotations of a flannel subnet lease that override the route priority and
+// the comma separated instance tags for that subnet.
+const (
+	PriorityAnnotation = "flannel-route-manager/google-priority"
+	TagsAnnotation     = "flannel-route-manager/google-tags"
+)
+
+type annotationStore struct {
+	mu sync.Mutex
+	m  map[string]map[string]string
+}
+
+// retain drops the annotations of the subnets not in routes, whose leases
+// are gone.
+func (s *annotationStore) retain(routes backend.RouteTable) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for subnet := range s.m {
+		if _, ok := routes[subnet]; !ok {
+			delete(s.m, subnet)
+		}
+	}
+}
+
+// settings returns the priority and tags of the route to subnet.
+func (rm networkManager) settings(subnet string) (int64, []string) {
+	s := rm.annotations
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	priority, tags := rm.priority, rm.tags
+	a := s.m[subnet]
+	if v, ok := a[PriorityAnnotation]; ok {
+		p, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || p < 0 || p > 65535 {
+			log.Printf("google: ignoring invalid %s %q for %s\n", PriorityAnnotation, v, subnet)
+		} else {
+			priority = p
+		}
+	}
+	if v, ok := a[TagsAnnotation]; ok {
+		tags = []string{}
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return priority, tags
+}
+
+// state describes the parts of route that Sync keeps in line with the flannel
+// route table: next hop, priority and tags.
+func (rm networkManager) state(route *compute.Route) string {
+	nextHop := route.NextHopIp
+	if rm.nextHopInstance {
+		nextHop = route.NextHopInstance
+	}
+	tags := append([]string{}, route.Tags...)
+	sort.Strings(tags)
+	return fmt.Sprintf("%s priority %d tags %s", nextHop, route.Priority, strings.Join(tags, ","))
+}
@@ -0,0 +1,129 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+type route struct {
+	ip    string
+	owned bool
+}
+
+// RouteManager keeps the route table in memory. It backs the conformance
+// tests and lets the server run without any infrastructure.
+type RouteManager struct {
+	mu     sync.Mutex
+	routes map[string]route
+}
+
+func New() *RouteManager {
+	return &RouteManager{routes: make(map[string]route)}
+}
+
+// AddForeignRoute adds a route the route manager doesn't own, as if it had
+// been created by someone else.
+func (rm *RouteManager) AddForeignRoute(ip, subnet string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.routes[subnet] = route{ip: ip}
+}
+
+// Routes returns all routes, owned or not, as a map of subnet to next hop.
+func (rm *RouteManager) Routes() map[string]string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	m := make(map[string]string)
+	for subnet, r := range rm.routes {
+		m[subnet] = r.ip
+	}
+	return m
+}
+
+func (rm *RouteManager) Delete(subnet string) (string, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if r, ok := rm.routes[subnet]; ok && r.owned {
+		delete(rm.routes, subnet)
+	}
+	return subnet, nil
+}
+
+func (rm *RouteManager) DeleteAllRoutes() ([]string, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	deleted := []string{}
+	for subnet, r := range rm.routes {
+		if r.owned {
+			delete(rm.routes, subnet)
+			deleted = append(deleted, subnet)
+		}
+	}
+	return deleted, nil
+}
+
+func (rm *RouteManager) Insert(ip, subnet string) (string, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return subnet, rm.insert(ip, subnet)
+}
+
+func (rm *RouteManager) Plan(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	changes := rm.plan(routes)
+	response := backend.NewSyncResponse()
+	response.Deleted = changes.Delete
+	response.Inserted = changes.Insert
+	response.Replaced = changes.Replace
+	response.Unchanged = changes.Unchanged
+	return response, nil
+}
+
+func (rm *RouteManager) Sync(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	response := backend.NewSyncResponse()
+	changes := rm.plan(routes)
+	for _, subnet := range changes.Delete {
+		delete(rm.routes, subnet)
+		response.Deleted = append(response.Deleted, subnet)
+	}
+	for _, subnet := range changes.Replace {
+		rm.routes[subnet] = route{ip: routes[subnet], owned: true}
+		response.Replaced = append(response.Replaced, subnet)
+	}
+	for _, subnet := range changes.Insert {
+		if err := rm.insert(routes[subnet], subnet); err != nil {
+			response.Errors = append(response.Errors, &backend.RouteError{Route: subnet, Err: err})
+			continue
+		}
+		response.Inserted = append(response.Inserted, subnet)
+	}
+	response.Unchanged = changes.Unchanged
+	if len(response.Errors) > 0 {
+		return response, fmt.Errorf("memory: %d routes failed to sync", len(response.Errors))
+	}
+	return response, nil
+}
+
+// plan compares the owned routes with in.
+func (rm *RouteManager) plan(in backend.RouteTable) *backend.Changes {
+	current := make(backend.RouteTable)
+	for subnet, r := range rm.routes {
+		if r.owned {
+			current[subnet] = r.ip
+		}
+	}
+	return backend.Diff(in, current)
+}
+
+func (rm *RouteManager) insert(ip, subnet string) error {
+	if r, ok := rm.routes[subnet]; ok && !r.owned {
+		return fmt.Errorf("memory: %s is taken by a foreign route", subnet)
+	}
+	rm.routes[subnet] = route{ip: ip, owned: true}
+	return nil
+}
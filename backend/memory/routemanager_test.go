@@ -0,0 +1,36 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/backendtest"
+)
+
+func TestConformance(t *testing.T) {
+	var rm *RouteManager
+	backendtest.Run(t, &backendtest.Harness{
+		New: func(t *testing.T) backend.RouteManager {
+			rm = New()
+			return rm
+		},
+		Routes: func(t *testing.T) map[string]string {
+			return rm.Routes()
+		},
+		AddForeignRoute: func(t *testing.T, ip, subnet string) {
+			rm.AddForeignRoute(ip, subnet)
+		},
+	})
+}
+
+func TestSyncOverForeignRoute(t *testing.T) {
+	rm := New()
+	rm.AddForeignRoute("10.240.0.100", "10.244.1.0/24")
+	resp, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.2"})
+	if err == nil || len(resp.Errors) != 1 || resp.Errors[0].Route != "10.244.1.0/24" {
+		t.Errorf("Sync over a foreign route returned %v with errors %v", err, resp.Errors)
+	}
+	if got := rm.Routes()["10.244.1.0/24"]; got != "10.240.0.100" {
+		t.Errorf("foreign route now goes via %s", got)
+	}
+}
@@ -0,0 +1,214 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+type conn struct {
+	mu  sync.Mutex
+	fd  int
+	seq uint32
+}
+
+// dial opens a NETLINK_ROUTE socket in the network namespace of the
+// calling process.
+func dial() (*conn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &conn{fd: fd}, nil
+}
+
+// replaceRoute installs the route to dst via gw. An existing route to dst is
+// only replaced if it carries protocol; a route of another owner is left
+// alone and reported as a conflict.
+func (c *conn) replaceRoute(table uint32, protocol uint8, dst *net.IPNet, gw net.IP) error {
+	rs, err := c.dumpRoutes(table)
+	if err != nil {
+		return err
+	}
+	flags := syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | syscall.NLM_F_CREATE | syscall.NLM_F_EXCL
+	for _, r := range rs {
+		if r.dst.String() != dst.String() {
+			continue
+		}
+		if r.protocol != protocol {
+			return conflictError(table, dst, r.protocol)
+		}
+		flags = syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | syscall.NLM_F_CREATE | syscall.NLM_F_REPLACE
+	}
+	attrs := routeAttrs(table, dst)
+	attrs = append(attrs, rtattr(syscall.RTA_GATEWAY, gw.To4())...)
+	_, err = c.request(syscall.RTM_NEWROUTE, flags, rtmsg(table, protocol, dst), attrs)
+	if err == syscall.EEXIST {
+		// Someone else added a route between the dump and the insert.
+		return fmt.Errorf("netlink: route to %s in table %d appeared while inserting it", dst, table)
+	}
+	return err
+}
+
+func conflictError(table uint32, dst *net.IPNet, protocol uint8) error {
+	return fmt.Errorf("netlink: route to %s in table %d is owned by protocol %d", dst, table, protocol)
+}
+
+func (c *conn) deleteRoute(table uint32, protocol uint8, dst *net.IPNet) error {
+	flags := syscall.NLM_F_REQUEST | syscall.NLM_F_ACK
+	_, err := c.request(syscall.RTM_DELROUTE, flags, rtmsg(table, protocol, dst), routeAttrs(table, dst))
+	if err == syscall.ESRCH {
+		return nil
+	}
+	return err
+}
+
+// listRoutes returns the routes in table that carry protocol.
+func (c *conn) listRoutes(table uint32, protocol uint8) ([]route, error) {
+	rs, err := c.dumpRoutes(table)
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]route, 0, len(rs))
+	for _, r := range rs {
+		if r.protocol == protocol {
+			owned = append(owned, r)
+		}
+	}
+	return owned, nil
+}
+
+// dumpRoutes returns all IPv4 routes in table.
+func (c *conn) dumpRoutes(table uint32) ([]route, error) {
+	flags := syscall.NLM_F_REQUEST | syscall.NLM_F_DUMP
+	msgs, err := c.request(syscall.RTM_GETROUTE, flags, &syscall.RtMsg{Family: syscall.AF_INET}, nil)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]route, 0)
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		rtm := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if rtm.Family != syscall.AF_INET {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return nil, err
+		}
+		t := uint32(rtm.Table)
+		r := route{
+			dst:      &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(int(rtm.Dst_len), 32)},
+			protocol: rtm.Protocol,
+		}
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.RTA_TABLE:
+				t = *(*uint32)(unsafe.Pointer(&a.Value[0]))
+			case syscall.RTA_DST:
+				r.dst.IP = net.IP(append([]byte{}, a.Value...))
+			case syscall.RTA_GATEWAY:
+				r.gateway = net.IP(append([]byte{}, a.Value...))
+			}
+		}
+		if t == table {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+// request sends a single netlink message and collects the replies until the
+// kernel acknowledges it or finishes the dump.
+func (c *conn) request(typ, flags int, rtm *syscall.RtMsg, attrs []byte) ([]syscall.NetlinkMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	body := (*[syscall.SizeofRtMsg]byte)(unsafe.Pointer(rtm))[:]
+	b := make([]byte, syscall.SizeofNlMsghdr, syscall.SizeofNlMsghdr+len(body)+len(attrs))
+	b = append(b, body...)
+	b = append(b, attrs...)
+	*(*syscall.NlMsghdr)(unsafe.Pointer(&b[0])) = syscall.NlMsghdr{
+		Len:   uint32(len(b)),
+		Type:  uint16(typ),
+		Flags: uint16(flags),
+		Seq:   c.seq,
+	}
+	if err := syscall.Sendto(c.fd, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+	var replies []syscall.NetlinkMessage
+	for {
+		// Parsed messages reference buf, so it can't be reused across reads.
+		buf := make([]byte, 1<<16)
+		n, _, err := syscall.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != c.seq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("netlink: short error message")
+				}
+				if errno := *(*int32)(unsafe.Pointer(&m.Data[0])); errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+				return replies, nil
+			}
+			replies = append(replies, m)
+		}
+	}
+}
+
+func rtmsg(table uint32, protocol uint8, dst *net.IPNet) *syscall.RtMsg {
+	ones, _ := dst.Mask.Size()
+	rtm := &syscall.RtMsg{
+		Family:   syscall.AF_INET,
+		Dst_len:  uint8(ones),
+		Table:    syscall.RT_TABLE_COMPAT,
+		Protocol: protocol,
+		Scope:    syscall.RT_SCOPE_UNIVERSE,
+		Type:     syscall.RTN_UNICAST,
+	}
+	if table < 256 {
+		rtm.Table = uint8(table)
+	}
+	return rtm
+}
+
+func routeAttrs(table uint32, dst *net.IPNet) []byte {
+	t := make([]byte, 4)
+	*(*uint32)(unsafe.Pointer(&t[0])) = table
+	b := rtattr(syscall.RTA_TABLE, t)
+	return append(b, rtattr(syscall.RTA_DST, dst.IP.To4())...)
+}
+
+func rtattr(typ int, data []byte) []byte {
+	l := syscall.SizeofRtAttr + len(data)
+	b := make([]byte, (l+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	*(*syscall.RtAttr)(unsafe.Pointer(&b[0])) = syscall.RtAttr{Len: uint16(l), Type: uint16(typ)}
+	copy(b[syscall.SizeofRtAttr:], data)
+	return b
+}
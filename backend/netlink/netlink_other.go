@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package netlink
+
+import (
+	"errors"
+	"net"
+)
+
+type conn struct{}
+
+func dial() (*conn, error) {
+	return nil, errors.New("netlink: only supported on linux")
+}
+
+func (c *conn) replaceRoute(table uint32, protocol uint8, dst *net.IPNet, gw net.IP) error {
+	return errors.New("netlink: only supported on linux")
+}
+
+func (c *conn) deleteRoute(table uint32, protocol uint8, dst *net.IPNet) error {
+	return errors.New("netlink: only supported on linux")
+}
+
+func (c *conn) listRoutes(table uint32, protocol uint8) ([]route, error) {
+	return nil, errors.New("netlink: only supported on linux")
+}
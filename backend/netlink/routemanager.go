@@ -0,0 +1,196 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+const (
+	DefaultProtocol = 200
+	DefaultTable    = 254
+)
+
+type Config struct {
+	// Protocol is the rtm_protocol tag set on every installed route. Only
+	// routes carrying this tag in Table are considered owned.
+	Protocol int
+	Table    int
+}
+
+type route struct {
+	dst      *net.IPNet
+	gateway  net.IP
+	protocol uint8
+}
+
+type RouteManager struct {
+	conn     *conn
+	protocol uint8
+	table    uint32
+}
+
+func New(config *Config) (*RouteManager, error) {
+	if config.Protocol < 1 || config.Protocol > 255 {
+		return nil, fmt.Errorf("netlink: invalid protocol %d", config.Protocol)
+	}
+	if config.Table < 1 {
+		return nil, fmt.Errorf("netlink: invalid table %d", config.Table)
+	}
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	rm := &RouteManager{
+		conn:     c,
+		protocol: uint8(config.Protocol),
+		table:    uint32(config.Table),
+	}
+	return rm, nil
+}
+
+func (rm *RouteManager) Delete(subnet string) (string, error) {
+	dst, err := parseSubnet(subnet)
+	if err != nil {
+		return subnet, err
+	}
+	return subnet, rm.conn.deleteRoute(rm.table, rm.protocol, dst)
+}
+
+func (rm *RouteManager) DeleteAllRoutes() ([]string, error) {
+	deleted := []string{}
+	var lastError error
+	rs, err := rm.conn.listRoutes(rm.table, rm.protocol)
+	if err != nil {
+		return deleted, err
+	}
+	for _, r := range rs {
+		if err := rm.conn.deleteRoute(rm.table, rm.protocol, r.dst); err != nil {
+			lastError = err
+		}
+		deleted = append(deleted, r.dst.String())
+	}
+	return deleted, lastError
+}
+
+func (rm *RouteManager) Insert(ip, subnet string) (string, error) {
+	dst, err := parseSubnet(subnet)
+	if err != nil {
+		return subnet, err
+	}
+	gw := net.ParseIP(ip).To4()
+	if gw == nil {
+		return subnet, fmt.Errorf("netlink: invalid IPv4 address %q", ip)
+	}
+	return subnet, rm.conn.replaceRoute(rm.table, rm.protocol, dst, gw)
+}
+
+func (rm *RouteManager) Plan(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	p, err := rm.plan(routes)
+	if err != nil {
+		return backend.NewSyncResponse(), err
+	}
+	return p.Response(routeName), nil
+}
+
+func (rm *RouteManager) Sync(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	return rm.sync(routes)
+}
+
+// sync applies the plan for in. Routes that fail, e.g. because a route of
+// another owner has the same destination, are reported in the response
+// errors.
+func (rm *RouteManager) sync(in backend.RouteTable) (*backend.SyncResponse, error) {
+	response := backend.NewSyncResponse()
+	p, err := rm.plan(in)
+	if err != nil {
+		return response, err
+	}
+	for _, subnet := range p.Delete {
+		r := p.current[subnet]
+		if err := rm.conn.deleteRoute(rm.table, rm.protocol, r.dst); err != nil {
+			response.Errors = append(response.Errors, &backend.RouteError{Route: r.dst.String(), Err: err})
+			continue
+		}
+		response.Deleted = append(response.Deleted, r.dst.String())
+	}
+	for _, subnet := range p.Replace {
+		r := p.desired[subnet]
+		if err := rm.conn.replaceRoute(rm.table, rm.protocol, r.dst, r.gateway); err != nil {
+			response.Errors = append(response.Errors, &backend.RouteError{Route: r.dst.String(), Err: err})
+			continue
+		}
+		response.Replaced = append(response.Replaced, r.dst.String())
+	}
+	for _, subnet := range p.Insert {
+		r := p.desired[subnet]
+		if err := rm.conn.replaceRoute(rm.table, rm.protocol, r.dst, r.gateway); err != nil {
+			response.Errors = append(response.Errors, &backend.RouteError{Route: r.dst.String(), Err: err})
+			continue
+		}
+		response.Inserted = append(response.Inserted, r.dst.String())
+	}
+	response.Unchanged = append(response.Unchanged, p.Unchanged...)
+	if len(response.Errors) > 0 {
+		return response, fmt.Errorf("netlink: %d routes failed to sync", len(response.Errors))
+	}
+	return response, nil
+}
+
+// routePlan is the diff between the owned routes and a flannel route table,
+// keyed by destination.
+type routePlan struct {
+	*backend.Changes
+	current map[string]route
+	desired map[string]route
+}
+
+func routeName(subnet string) string {
+	return subnet
+}
+
+// plan compares the owned routes with in without changing anything.
+func (rm *RouteManager) plan(in backend.RouteTable) (*routePlan, error) {
+	desired := make(map[string]route)
+	desiredTable := make(backend.RouteTable)
+	for subnet, ip := range in {
+		dst, err := parseSubnet(subnet)
+		if err != nil {
+			return nil, err
+		}
+		gw := net.ParseIP(ip).To4()
+		if gw == nil {
+			return nil, fmt.Errorf("netlink: invalid IPv4 address %q", ip)
+		}
+		desired[dst.String()] = route{dst: dst, gateway: gw}
+		desiredTable[dst.String()] = gw.String()
+	}
+	rs, err := rm.conn.listRoutes(rm.table, rm.protocol)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]route)
+	currentTable := make(backend.RouteTable)
+	for _, r := range rs {
+		current[r.dst.String()] = r
+		currentTable[r.dst.String()] = r.gateway.String()
+	}
+	p := &routePlan{
+		Changes: backend.Diff(desiredTable, currentTable),
+		current: current,
+		desired: desired,
+	}
+	return p, nil
+}
+
+func parseSubnet(subnet string) (*net.IPNet, error) {
+	_, dst, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	if dst.IP.To4() == nil {
+		return nil, fmt.Errorf("netlink: %s is not an IPv4 subnet", subnet)
+	}
+	return dst, nil
+}
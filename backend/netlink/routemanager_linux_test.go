@@ -0,0 +1,118 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/backendtest"
+)
+
+const (
+	netnsEnv  = "NETLINK_TEST_NETNS"
+	testTable = "100"
+)
+
+// inNetns runs the calling test again in a child process in a new network
+// namespace with 10.240.0.1/24 on the loopback interface. It returns true in
+// the child, where the test goes on, and false in the parent.
+func inNetns(t *testing.T) bool {
+	if os.Getenv(netnsEnv) != "" {
+		ip(t, "link", "set", "lo", "up")
+		ip(t, "addr", "add", "10.240.0.1/24", "dev", "lo")
+		return true
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("ip not found")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), netnsEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v in network namespace:\n%s", err, out)
+	}
+	return false
+}
+
+func ip(t *testing.T, args ...string) string {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ip %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func newTestRouteManager(t *testing.T) *RouteManager {
+	// Flushing fails when the table doesn't exist yet.
+	exec.Command("ip", "route", "flush", "table", testTable).Run()
+	rm, err := New(&Config{Protocol: DefaultProtocol, Table: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+// routes returns the routes in the test table as a map of subnet to next hop.
+func routes(t *testing.T) map[string]string {
+	m := make(map[string]string)
+	out, err := exec.Command("ip", "route", "show", "table", testTable).CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "does not exist") {
+		t.Fatalf("ip route show: %v: %s", err, out)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if f := strings.Fields(line); len(f) >= 3 && f[1] == "via" {
+			m[f[0]] = f[2]
+		}
+	}
+	return m
+}
+
+func addForeignRoute(t *testing.T, gw, subnet string) {
+	ip(t, "route", "add", subnet, "via", gw, "table", testTable)
+}
+
+func TestConformance(t *testing.T) {
+	if !inNetns(t) {
+		return
+	}
+	backendtest.Run(t, &backendtest.Harness{
+		New:             func(t *testing.T) backend.RouteManager { return newTestRouteManager(t) },
+		Routes:          routes,
+		AddForeignRoute: addForeignRoute,
+	})
+}
+
+func TestForeignRouteConflict(t *testing.T) {
+	if !inNetns(t) {
+		return
+	}
+	rm := newTestRouteManager(t)
+	addForeignRoute(t, "10.240.0.100", "10.244.1.0/24")
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err == nil || !strings.Contains(err.Error(), "owned by protocol") {
+		t.Errorf("Insert over a foreign route returned %v, want a conflict", err)
+	}
+	resp, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.2", "10.244.2.0/24": "10.240.0.3"})
+	if err == nil || len(resp.Errors) != 1 || resp.Errors[0].Route != "10.244.1.0/24" {
+		t.Errorf("Sync over a foreign route returned %v with errors %v, want a conflict for 10.244.1.0/24", err, resp.Errors)
+	}
+	if len(resp.Inserted) != 1 {
+		t.Errorf("Sync inserted %v, want 10.244.2.0/24", resp.Inserted)
+	}
+	got := routes(t)
+	if got["10.244.1.0/24"] != "10.240.0.100" || got["10.244.2.0/24"] != "10.240.0.3" {
+		t.Errorf("routes are %v, want the foreign route kept", got)
+	}
+	if out := ip(t, "route", "show", "table", testTable, "10.244.1.0/24"); strings.Contains(out, "proto 200") {
+		t.Errorf("foreign route was taken over: %s", out)
+	}
+}
@@ -0,0 +1,138 @@
+package openstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type credentials struct {
+	authURL     string
+	domainName  string
+	password    string
+	projectName string
+	region      string
+	username    string
+}
+
+type token struct {
+	id      string
+	expires time.Time
+	catalog []catalogEntry
+}
+
+type catalogEntry struct {
+	Type      string `json:"type"`
+	Endpoints []struct {
+		Interface string `json:"interface"`
+		Region    string `json:"region"`
+		URL       string `json:"url"`
+	} `json:"endpoints"`
+}
+
+// keystone authenticates against the Keystone v3 identity API with a
+// password and caches the resulting project scoped token.
+type keystone struct {
+	client *http.Client
+	creds  credentials
+
+	mu    sync.Mutex
+	token *token
+}
+
+func (k *keystone) get() (*token, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.token != nil && time.Now().Add(5*time.Minute).Before(k.token.expires) {
+		return k.token, nil
+	}
+	t, err := k.authenticate()
+	if err != nil {
+		return nil, err
+	}
+	k.token = t
+	return t, nil
+}
+
+// invalidate drops the cached token, e.g. after it got rejected.
+func (k *keystone) invalidate() {
+	k.mu.Lock()
+	k.token = nil
+	k.mu.Unlock()
+}
+
+func (k *keystone) authenticate() (*token, error) {
+	domain := map[string]string{"name": k.creds.domainName}
+	body := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"identity": map[string]interface{}{
+				"methods": []string{"password"},
+				"password": map[string]interface{}{
+					"user": map[string]interface{}{
+						"name":     k.creds.username,
+						"password": k.creds.password,
+						"domain":   domain,
+					},
+				},
+			},
+			"scope": map[string]interface{}{
+				"project": map[string]interface{}{
+					"name":   k.creds.projectName,
+					"domain": domain,
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(k.creds.authURL, "/") + "/auth/tokens"
+	resp, err := k.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("openstack: keystone authentication failed: %s", resp.Status)
+	}
+	var result struct {
+		Token struct {
+			ExpiresAt time.Time      `json:"expires_at"`
+			Catalog   []catalogEntry `json:"catalog"`
+		} `json:"token"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &token{
+		id:      resp.Header.Get("X-Subject-Token"),
+		expires: result.Token.ExpiresAt,
+		catalog: result.Token.Catalog,
+	}, nil
+}
+
+// endpoint returns the public URL of the service of type typ from the
+// service catalog.
+func (t *token) endpoint(typ, region string) (string, error) {
+	for _, e := range t.catalog {
+		if e.Type != typ {
+			continue
+		}
+		for _, ep := range e.Endpoints {
+			if ep.Interface == "public" && (region == "" || ep.Region == region) {
+				return ep.URL, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("openstack: no public %s endpoint in region %q", typ, region)
+}
@@ -0,0 +1,113 @@
+package openstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+type neutronRoute struct {
+	Destination string `json:"destination"`
+	NextHop     string `json:"nexthop"`
+}
+
+type router struct {
+	Routes   []neutronRoute `json:"routes"`
+	Revision int            `json:"revision_number"`
+}
+
+type neutronError struct {
+	status  int
+	message string
+}
+
+func (e *neutronError) Error() string {
+	return fmt.Sprintf("openstack: neutron: %d %s", e.status, e.message)
+}
+
+type neutronClient struct {
+	client   *http.Client
+	endpoint string
+	keystone *keystone
+	region   string
+}
+
+func (c *neutronClient) getRouter(id string) (*router, error) {
+	var resp struct {
+		Router router `json:"router"`
+	}
+	if err := c.do("GET", "/v2.0/routers/"+id, nil, "", &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Router, nil
+}
+
+// updateRoutes replaces the routes of the router. The update is rejected
+// with 412 Precondition Failed if the router changed since revision.
+func (c *neutronClient) updateRoutes(id string, routes []neutronRoute, revision int) error {
+	body := map[string]interface{}{
+		"router": map[string]interface{}{"routes": routes},
+	}
+	ifMatch := ""
+	if revision > 0 {
+		ifMatch = fmt.Sprintf("revision_number=%d", revision)
+	}
+	return c.do("PUT", "/v2.0/routers/"+id, body, ifMatch, nil)
+}
+
+func (c *neutronClient) do(method, path string, in interface{}, ifMatch string, out interface{}) error {
+	var data []byte
+	if in != nil {
+		var err error
+		if data, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		t, err := c.keystone.get()
+		if err != nil {
+			return err
+		}
+		endpoint := c.endpoint
+		if endpoint == "" {
+			if endpoint, err = t.endpoint("network", c.region); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequest(method, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Auth-Token", t.id)
+		req.Header.Set("Accept", "application/json")
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			c.keystone.invalidate()
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &neutronError{status: resp.StatusCode, message: strings.TrimSpace(string(body))}
+		}
+		if out != nil {
+			return json.Unmarshal(body, out)
+		}
+		return nil
+	}
+}
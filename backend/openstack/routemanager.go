@@ -0,0 +1,240 @@
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+// maxUpdateAttempts bounds how often a router update is retried after losing
+// a race against a concurrent update.
+const maxUpdateAttempts = 5
+
+type Config struct {
+	// AuthURL, Username, DomainName, ProjectName and Region default to
+	// $OS_AUTH_URL, $OS_USERNAME, $OS_USER_DOMAIN_NAME, $OS_PROJECT_NAME and
+	// $OS_REGION_NAME. The password is always read from $OS_PASSWORD.
+	AuthURL     string
+	DomainName  string
+	ProjectName string
+	Region      string
+	Username    string
+	// ClusterCIDR is the flannel network. Router routes with a destination
+	// inside it are considered owned by the route manager; all other routes
+	// are left untouched.
+	ClusterCIDR string
+	// Endpoint overrides the Neutron endpoint from the service catalog.
+	Endpoint string
+	RouterID string
+}
+
+type RouteManager struct {
+	clusterNet *net.IPNet
+	neutron    *neutronClient
+	routerID   string
+}
+
+func New(config *Config) (*RouteManager, error) {
+	if config.RouterID == "" {
+		return nil, errors.New("openstack: router ID is required")
+	}
+	_, clusterNet, err := net.ParseCIDR(config.ClusterCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("openstack: invalid cluster CIDR %q: %v", config.ClusterCIDR, err)
+	}
+	creds := credentials{
+		authURL:     valueOrEnv(config.AuthURL, "OS_AUTH_URL"),
+		domainName:  valueOrEnv(config.DomainName, "OS_USER_DOMAIN_NAME"),
+		password:    os.Getenv("OS_PASSWORD"),
+		projectName: valueOrEnv(config.ProjectName, "OS_PROJECT_NAME"),
+		region:      valueOrEnv(config.Region, "OS_REGION_NAME"),
+		username:    valueOrEnv(config.Username, "OS_USERNAME"),
+	}
+	if creds.authURL == "" {
+		return nil, errors.New("openstack: auth URL is required")
+	}
+	if creds.domainName == "" {
+		creds.domainName = "Default"
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	rm := &RouteManager{
+		clusterNet: clusterNet,
+		neutron: &neutronClient{
+			client:   client,
+			endpoint: config.Endpoint,
+			keystone: &keystone{client: client, creds: creds},
+			region:   creds.region,
+		},
+		routerID: config.RouterID,
+	}
+	return rm, nil
+}
+
+func (rm *RouteManager) Delete(subnet string) (string, error) {
+	err := rm.update(func(routes []neutronRoute) []neutronRoute {
+		rs := make([]neutronRoute, 0, len(routes))
+		for _, r := range routes {
+			if !rm.owned(r) || r.Destination != subnet {
+				rs = append(rs, r)
+			}
+		}
+		return rs
+	})
+	return subnet, err
+}
+
+func (rm *RouteManager) DeleteAllRoutes() ([]string, error) {
+	var deleted []string
+	err := rm.update(func(routes []neutronRoute) []neutronRoute {
+		deleted = []string{}
+		rs := make([]neutronRoute, 0, len(routes))
+		for _, r := range routes {
+			if rm.owned(r) {
+				deleted = append(deleted, r.Destination)
+				continue
+			}
+			rs = append(rs, r)
+		}
+		return rs
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	return deleted, nil
+}
+
+func (rm *RouteManager) Insert(ip, subnet string) (string, error) {
+	err := rm.update(func(routes []neutronRoute) []neutronRoute {
+		rs := make([]neutronRoute, 0, len(routes)+1)
+		for _, r := range routes {
+			if !rm.owned(r) || r.Destination != subnet {
+				rs = append(rs, r)
+			}
+		}
+		return append(rs, neutronRoute{Destination: subnet, NextHop: ip})
+	})
+	return subnet, err
+}
+
+func (rm *RouteManager) Plan(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	r, err := rm.neutron.getRouter(rm.routerID)
+	if err != nil {
+		return backend.NewSyncResponse(), err
+	}
+	_, response := rm.plan(routes, r.Routes)
+	return response, nil
+}
+
+func (rm *RouteManager) Sync(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	return rm.sync(routes)
+}
+
+func (rm *RouteManager) sync(in backend.RouteTable) (*backend.SyncResponse, error) {
+	var response *backend.SyncResponse
+	err := rm.update(func(routes []neutronRoute) []neutronRoute {
+		var rs []neutronRoute
+		rs, response = rm.plan(in, routes)
+		return rs
+	})
+	if err != nil {
+		return backend.NewSyncResponse(), err
+	}
+	return response, nil
+}
+
+// plan merges in into the current routes of the router. It returns the new
+// route list along with what changed. Owned destinations listed more than
+// once are replaced by a single route.
+func (rm *RouteManager) plan(in backend.RouteTable, routes []neutronRoute) ([]neutronRoute, *backend.SyncResponse) {
+	current := make(backend.RouteTable)
+	rs := make([]neutronRoute, 0, len(routes))
+	for _, r := range routes {
+		if !rm.owned(r) {
+			rs = append(rs, r)
+			continue
+		}
+		if _, ok := current[r.Destination]; ok {
+			current[r.Destination] = ""
+			continue
+		}
+		current[r.Destination] = r.NextHop
+	}
+	changes := backend.Diff(in, current)
+	response := backend.NewSyncResponse()
+	response.Deleted = changes.Delete
+	response.Inserted = changes.Insert
+	response.Replaced = changes.Replace
+	response.Unchanged = changes.Unchanged
+	subnets := make([]string, 0, len(in))
+	for subnet := range in {
+		subnets = append(subnets, subnet)
+	}
+	sort.Strings(subnets)
+	for _, subnet := range subnets {
+		rs = append(rs, neutronRoute{Destination: subnet, NextHop: in[subnet]})
+	}
+	return rs, response
+}
+
+// update applies fn to the current routes of the router and writes the
+// result back. Neutron replaces the whole route list on update, so the write
+// is made conditional on the router revision it was computed from, and fn
+// is re-applied to fresh state whenever a concurrent update wins the race.
+func (rm *RouteManager) update(fn func([]neutronRoute) []neutronRoute) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		r, err := rm.neutron.getRouter(rm.routerID)
+		if err != nil {
+			return err
+		}
+		routes := fn(r.Routes)
+		if equalRoutes(routes, r.Routes) {
+			return nil
+		}
+		err = rm.neutron.updateRoutes(rm.routerID, routes, r.Revision)
+		if e, ok := err.(*neutronError); ok && e.status == http.StatusPreconditionFailed {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("openstack: router %s: too many concurrent updates", rm.routerID)
+}
+
+func (rm *RouteManager) owned(r neutronRoute) bool {
+	_, dest, err := net.ParseCIDR(r.Destination)
+	if err != nil {
+		return false
+	}
+	ones, _ := dest.Mask.Size()
+	clusterOnes, _ := rm.clusterNet.Mask.Size()
+	return ones > clusterOnes && rm.clusterNet.Contains(dest.IP)
+}
+
+func equalRoutes(a, b []neutronRoute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[neutronRoute]int)
+	for _, r := range a {
+		set[r]++
+	}
+	for _, r := range b {
+		if set[r] == 0 {
+			return false
+		}
+		set[r]--
+	}
+	return true
+}
+
+func valueOrEnv(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
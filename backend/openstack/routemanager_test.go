@@ -0,0 +1,220 @@
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/backendtest"
+)
+
+// fakeOpenStack is a stand-in for Keystone and for the Neutron router r1.
+// Its service catalog points the network service of region test at itself.
+type fakeOpenStack struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	router   router
+	tokens   int
+	updates  int
+	conflict func()
+	expire   bool
+}
+
+func newFakeOpenStack(t *testing.T) *fakeOpenStack {
+	f := &fakeOpenStack{t: t, router: router{Routes: []neutronRoute{}, Revision: 1}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	t.Setenv("OS_PASSWORD", "password")
+	return f
+}
+
+func (f *fakeOpenStack) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/v3/auth/tokens" {
+		f.authenticate(w, r)
+		return
+	}
+	if r.URL.Path != "/v2.0/routers/r1" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("X-Auth-Token") != fmt.Sprintf("token-%d", f.tokens) || f.expire {
+		f.expire = false
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{"router": f.router})
+	case "PUT":
+		if f.conflict != nil {
+			// Someone else updates the router between our GET and PUT.
+			f.conflict()
+			f.conflict = nil
+		}
+		if want := fmt.Sprintf("revision_number=%d", f.router.Revision); r.Header.Get("If-Match") != want {
+			http.Error(w, "revision mismatch", http.StatusPreconditionFailed)
+			return
+		}
+		var body struct {
+			Router struct {
+				Routes []neutronRoute `json:"routes"`
+			} `json:"router"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.router.Routes = body.Router.Routes
+		f.router.Revision++
+		f.updates++
+		json.NewEncoder(w).Encode(map[string]interface{}{"router": f.router})
+	default:
+		http.Error(w, r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeOpenStack) authenticate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Auth struct {
+			Identity struct {
+				Password struct {
+					User struct {
+						Name     string `json:"name"`
+						Password string `json:"password"`
+					} `json:"user"`
+				} `json:"password"`
+			} `json:"identity"`
+		} `json:"auth"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if u := body.Auth.Identity.Password.User; u.Name != "admin" || u.Password != "password" {
+		http.Error(w, "bad credentials", http.StatusUnauthorized)
+		return
+	}
+	f.tokens++
+	w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", f.tokens))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"token": {"expires_at": %q, "catalog": [
+		{"type": "identity", "endpoints": [{"interface": "public", "region": "test", "url": "%s/v3"}]},
+		{"type": "network", "endpoints": [
+			{"interface": "internal", "region": "test", "url": "http://internal.invalid"},
+			{"interface": "public", "region": "other", "url": "http://other.invalid"},
+			{"interface": "public", "region": "test", "url": "%s/"}
+		]}
+	]}}`, time.Now().Add(time.Hour).Format(time.RFC3339), f.server.URL, f.server.URL)
+}
+
+func (f *fakeOpenStack) add(destination, nextHop string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.router.Routes = append(f.router.Routes, neutronRoute{Destination: destination, NextHop: nextHop})
+	f.router.Revision++
+}
+
+func (f *fakeOpenStack) routes() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := make(map[string]string)
+	for _, r := range f.router.Routes {
+		m[r.Destination] = r.NextHop
+	}
+	return m
+}
+
+func newTestRouteManager(t *testing.T, f *fakeOpenStack) *RouteManager {
+	rm, err := New(&Config{
+		AuthURL:     f.server.URL + "/v3",
+		ClusterCIDR: "10.244.0.0/16",
+		ProjectName: "flannel",
+		Region:      "test",
+		RouterID:    "r1",
+		Username:    "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+func TestConformance(t *testing.T) {
+	var f *fakeOpenStack
+	backendtest.Run(t, &backendtest.Harness{
+		New: func(t *testing.T) backend.RouteManager {
+			f = newFakeOpenStack(t)
+			return newTestRouteManager(t, f)
+		},
+		Routes: func(t *testing.T) map[string]string {
+			return f.routes()
+		},
+		AddForeignRoute: func(t *testing.T, ip, subnet string) {
+			f.add(subnet, ip)
+		},
+	})
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	f := newFakeOpenStack(t)
+	rm := newTestRouteManager(t, f)
+	f.conflict = func() {
+		f.router.Routes = append(f.router.Routes, neutronRoute{Destination: "192.168.0.0/24", NextHop: "10.240.0.100"})
+		f.router.Revision++
+	}
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	got := f.routes()
+	if len(got) != 2 || got["192.168.0.0/24"] != "10.240.0.100" || got["10.244.1.0/24"] != "10.240.0.2" {
+		t.Errorf("routes are %v, want both the concurrent and our route", got)
+	}
+	if f.updates != 1 {
+		t.Errorf("router updated %d times, want once after the conflict", f.updates)
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	f := newFakeOpenStack(t)
+	rm := newTestRouteManager(t, f)
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	f.expire = true
+	f.mu.Unlock()
+	if _, err := rm.Delete("10.244.1.0/24"); err != nil {
+		t.Fatalf("Delete after the token was revoked: %v", err)
+	}
+	if f.tokens != 2 || len(f.routes()) != 0 {
+		t.Errorf("got %d tokens and routes %v, want a new token and no routes", f.tokens, f.routes())
+	}
+}
+
+func TestNeutronError(t *testing.T) {
+	f := newFakeOpenStack(t)
+	rm, err := New(&Config{
+		AuthURL:     f.server.URL + "/v3",
+		ClusterCIDR: "10.244.0.0/16",
+		Region:      "test",
+		RouterID:    "missing",
+		Username:    "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if e, ok := err.(*neutronError); !ok || e.status != http.StatusNotFound || !strings.Contains(e.message, "not found") {
+		t.Errorf("Insert into a missing router returned %v", err)
+	}
+}
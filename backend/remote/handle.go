@@ -0,0 +1,57 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+// Handle serves req from rm, so a plugin or receiver written in Go can put
+// any backend.RouteManager behind the protocol.
+func Handle(rm backend.RouteManager, req *Request) *Response {
+	resp := &Response{}
+	if req.Version != ProtocolVersion {
+		resp.Error = fmt.Sprintf("unsupported protocol version %d", req.Version)
+		return resp
+	}
+	var err error
+	switch req.Command {
+	case "insert":
+		resp.Name, err = rm.Insert(req.IP, req.Subnet)
+	case "delete":
+		resp.Name, err = rm.Delete(req.Subnet)
+	case "delete-all":
+		resp.Deleted, err = rm.DeleteAllRoutes()
+	case "plan", "sync":
+		routes := make(backend.RouteTable)
+		for _, r := range req.Routes {
+			routes[r.Subnet] = r.IP
+		}
+		var sr *backend.SyncResponse
+		if req.Command == "sync" {
+			sr, err = rm.Sync(routes)
+		} else if planner, ok := rm.(backend.Planner); ok {
+			sr, err = planner.Plan(routes)
+		} else {
+			err = errors.New("plan is not supported")
+		}
+		if sr != nil {
+			resp.Deleted, resp.Inserted = sr.Deleted, sr.Inserted
+			resp.Replaced, resp.Unchanged = sr.Replaced, sr.Unchanged
+			for _, e := range sr.Errors {
+				resp.Errors = append(resp.Errors, RouteError{Route: e.Route, Error: e.Err.Error()})
+			}
+			if len(resp.Errors) > 0 {
+				// The route errors already say what failed.
+				err = nil
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown command %q", req.Command)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
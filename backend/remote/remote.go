@@ -0,0 +1,142 @@
+// Package remote implements the JSON protocol the exec and webhook backends
+// use to hand every operation to a program or service outside the
+// flannel-route-manager. See README.md for the protocol.
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+// ProtocolVersion is sent with every request so plugins and receivers can
+// reject requests they don't understand.
+const ProtocolVersion = 1
+
+type Request struct {
+	Version int         `json:"version"`
+	Command string      `json:"command"`
+	IP      string      `json:"ip,omitempty"`
+	Subnet  string      `json:"subnet,omitempty"`
+	Routes  []RouteInfo `json:"routes,omitempty"`
+}
+
+type RouteInfo struct {
+	IP     string `json:"ip"`
+	Subnet string `json:"subnet"`
+}
+
+type Response struct {
+	Name      string       `json:"name"`
+	Deleted   []string     `json:"deleted"`
+	Inserted  []string     `json:"inserted"`
+	Replaced  []string     `json:"replaced"`
+	Unchanged []string     `json:"unchanged"`
+	Error     string       `json:"error"`
+	Errors    []RouteError `json:"errors"`
+}
+
+type RouteError struct {
+	Route string `json:"route"`
+	Error string `json:"error"`
+}
+
+// Transport delivers a request and returns the response. A response
+// carrying an error is returned together with that error.
+type Transport interface {
+	Call(req *Request) (*Response, error)
+}
+
+// RouteManager implements backend.RouteManager by sending every operation
+// over a Transport.
+type RouteManager struct {
+	name      string
+	transport Transport
+}
+
+// NewRouteManager returns a route manager calling transport. name prefixes
+// the errors it returns itself, e.g. "webhook".
+func NewRouteManager(name string, transport Transport) *RouteManager {
+	return &RouteManager{name: name, transport: transport}
+}
+
+func (rm *RouteManager) Delete(subnet string) (string, error) {
+	resp, err := rm.call(&Request{Command: "delete", Subnet: subnet})
+	return responseName(resp, subnet), err
+}
+
+func (rm *RouteManager) DeleteAllRoutes() ([]string, error) {
+	resp, err := rm.call(&Request{Command: "delete-all"})
+	deleted := []string{}
+	if resp != nil && resp.Deleted != nil {
+		deleted = resp.Deleted
+	}
+	return deleted, err
+}
+
+func (rm *RouteManager) Insert(ip, subnet string) (string, error) {
+	resp, err := rm.call(&Request{Command: "insert", IP: ip, Subnet: subnet})
+	return responseName(resp, subnet), err
+}
+
+// Plan asks for the changes a sync would make. The plugin or receiver must
+// not change anything.
+func (rm *RouteManager) Plan(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	return rm.sync("plan", routes)
+}
+
+func (rm *RouteManager) Sync(routes backend.RouteTable) (*backend.SyncResponse, error) {
+	return rm.sync("sync", routes)
+}
+
+func (rm *RouteManager) sync(command string, routes backend.RouteTable) (*backend.SyncResponse, error) {
+	req := &Request{Command: command, Routes: []RouteInfo{}}
+	for subnet, ip := range routes {
+		req.Routes = append(req.Routes, RouteInfo{IP: ip, Subnet: subnet})
+	}
+	sort.Sort(bySubnet(req.Routes))
+	response := backend.NewSyncResponse()
+	resp, err := rm.call(req)
+	if resp == nil {
+		return response, err
+	}
+	if resp.Inserted != nil {
+		response.Inserted = resp.Inserted
+	}
+	if resp.Deleted != nil {
+		response.Deleted = resp.Deleted
+	}
+	if resp.Replaced != nil {
+		response.Replaced = resp.Replaced
+	}
+	if resp.Unchanged != nil {
+		response.Unchanged = resp.Unchanged
+	}
+	for _, e := range resp.Errors {
+		response.Errors = append(response.Errors, &backend.RouteError{Route: e.Route, Err: errors.New(e.Error)})
+	}
+	if err == nil && len(response.Errors) > 0 {
+		err = fmt.Errorf("%s: %s: %d routes failed", rm.name, command, len(response.Errors))
+	}
+	return response, err
+}
+
+func (rm *RouteManager) call(req *Request) (*Response, error) {
+	req.Version = ProtocolVersion
+	return rm.transport.Call(req)
+}
+
+func responseName(resp *Response, subnet string) string {
+	if resp == nil || resp.Name == "" {
+		return subnet
+	}
+	return resp.Name
+}
+
+type bySubnet []RouteInfo
+
+func (s bySubnet) Len() int           { return len(s) }
+func (s bySubnet) Less(i, j int) bool { return s[i].Subnet < s[j].Subnet }
+func (s bySubnet) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -4,10 +4,66 @@ type RouteManager interface {
 	Delete(route string) (string, error)
 	DeleteAllRoutes() ([]string, error)
 	Insert(ip, subnet string) (string, error)
-	Sync(map[string]string) (*SyncResponse, error)
+	Sync(RouteTable) (*SyncResponse, error)
 }
 
+// Planner is implemented by backends that can compute the changes Sync would
+// make without making them.
+type Planner interface {
+	Plan(RouteTable) (*SyncResponse, error)
+}
+
+// Annotator is implemented by backends that take per-subnet settings from
+// the annotations of flannel's subnet leases. Annotate is called before the
+// subnet is inserted, planned or synced; nil annotations clear them. Sync
+// may drop the annotations of subnets not in its route table.
+type Annotator interface {
+	Annotate(subnet string, annotations map[string]string)
+}
+
+// EtcdIndexAnnotation is added to the annotations of every subnet lease. It
+// holds the etcd index the lease was last modified at.
+const EtcdIndexAnnotation = "flannel-route-manager/etcd-index"
+
+// RouteTable maps each destination subnet to its next hop IP.
+type RouteTable map[string]string
+
+// SyncResponse reports what Sync did to every route it considered. A route
+// is replaced when its subnet is kept but its next hop changed.
 type SyncResponse struct {
-	Deleted  []string
-	Inserted []string
+	Deleted   []string
+	Errors    []*RouteError
+	Inserted  []string
+	Replaced  []string
+	Unchanged []string
+}
+
+// NewSyncResponse returns a SyncResponse with all route lists empty rather
+// than nil.
+func NewSyncResponse() *SyncResponse {
+	return &SyncResponse{
+		Deleted:   []string{},
+		Inserted:  []string{},
+		Replaced:  []string{},
+		Unchanged: []string{},
+	}
+}
+
+// Merge appends the routes of other to r.
+func (r *SyncResponse) Merge(other *SyncResponse) {
+	r.Deleted = append(r.Deleted, other.Deleted...)
+	r.Errors = append(r.Errors, other.Errors...)
+	r.Inserted = append(r.Inserted, other.Inserted...)
+	r.Replaced = append(r.Replaced, other.Replaced...)
+	r.Unchanged = append(r.Unchanged, other.Unchanged...)
+}
+
+// RouteError records a failure to sync a single route.
+type RouteError struct {
+	Route string
+	Err   error
+}
+
+func (e *RouteError) Error() string {
+	return e.Route + ": " + e.Err.Error()
 }
@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend/remote"
+)
+
+// retryBackoff is the mean wait before the first retry. It doubles with
+// every retry, up to a minute.
+var retryBackoff = time.Second
+
+type client struct {
+	client  *http.Client
+	retries int
+	secret  []byte
+	url     string
+}
+
+// Call posts req to the receiver, retrying transport errors, 429 and 5xx
+// responses with exponential backoff. Any other non-2xx response, or a
+// response carrying an error, fails the call right away.
+func (c *client) Call(req *remote.Request) (*remote.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, retry, err := c.post(req.Command, body)
+		if err == nil || !retry || attempt >= c.retries {
+			return resp, err
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("%v, retrying in %v\n", err, wait)
+		time.Sleep(wait)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (c *client) post(command string, body []byte) (*remote.Response, bool, error) {
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.secret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Flannel-Timestamp", timestamp)
+		req.Header.Set("X-Flannel-Signature", "sha256="+sign(c.secret, timestamp, body))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	var r remote.Response
+	jsonErr := json.Unmarshal(data, &r)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if jsonErr == nil && r.Error != "" {
+			return &r, retry, fmt.Errorf("webhook: %s: %s: %s", command, resp.Status, r.Error)
+		}
+		return nil, retry, fmt.Errorf("webhook: %s: %s", command, resp.Status)
+	}
+	if jsonErr != nil {
+		return nil, false, fmt.Errorf("webhook: %s: invalid response: %v", command, jsonErr)
+	}
+	if r.Error != "" {
+		return &r, false, fmt.Errorf("webhook: %s: %s", command, r.Error)
+	}
+	return &r, false, nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of timestamp, a dot and body.
+func sign(secret []byte, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(timestamp + "."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
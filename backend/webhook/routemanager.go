@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend/remote"
+)
+
+const (
+	DefaultRetries = 3
+	DefaultTimeout = 30 * time.Second
+)
+
+type Config struct {
+	// CAFile verifies the receiver instead of the system roots. CertFile and
+	// KeyFile hold the client certificate for mutual TLS.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Retries  int
+	// SecretFile holds the key used to sign requests with HMAC-SHA256.
+	SecretFile string
+	Timeout    time.Duration
+	URL        string
+}
+
+// RouteManager posts every call to a receiver, see README.md for the
+// protocol.
+type RouteManager struct {
+	*remote.RouteManager
+}
+
+func New(config *Config) (*RouteManager, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook: URL is required")
+	}
+	tlsConfig := &tls.Config{}
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("webhook: loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("webhook: no certificates found in %s", config.CAFile)
+		}
+	}
+	var secret []byte
+	if config.SecretFile != "" {
+		data, err := ioutil.ReadFile(config.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		if secret = bytes.TrimSpace(data); len(secret) == 0 {
+			return nil, fmt.Errorf("webhook: secret file %s is empty", config.SecretFile)
+		}
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	c := &client{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+		retries: config.Retries,
+		secret:  secret,
+		url:     config.URL,
+	}
+	rm := &RouteManager{
+		RouteManager: remote.NewRouteManager("webhook", c),
+	}
+	return rm, nil
+}
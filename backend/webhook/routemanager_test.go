@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/backendtest"
+	"github.com/kelseyhightower/flannel-route-manager/backend/memory"
+	"github.com/kelseyhightower/flannel-route-manager/backend/remote"
+)
+
+// receiver is a webhook receiver serving a memory route table. It checks
+// the signature of every request, and answers the next failures requests
+// with failStatus.
+type receiver struct {
+	t      *testing.T
+	server *httptest.Server
+	routes *memory.RouteManager
+	secret string
+
+	mu         sync.Mutex
+	requests   []string
+	failures   int
+	failStatus int
+}
+
+func newReceiver(t *testing.T, secret string) *receiver {
+	r := &receiver{t: t, routes: memory.New(), secret: secret}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *receiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.t.Error(err)
+		return
+	}
+	if r.secret != "" {
+		timestamp := req.Header.Get("X-Flannel-Timestamp")
+		want := "sha256=" + sign([]byte(r.secret), timestamp, body)
+		if got := req.Header.Get("X-Flannel-Signature"); got != want {
+			r.t.Errorf("X-Flannel-Signature is %q, want %q", got, want)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	var rr remote.Request
+	if err := json.Unmarshal(body, &rr); err != nil {
+		r.t.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.requests = append(r.requests, rr.Command)
+	if r.failures > 0 {
+		r.failures--
+		r.mu.Unlock()
+		w.WriteHeader(r.failStatus)
+		json.NewEncoder(w).Encode(&remote.Response{Error: http.StatusText(r.failStatus)})
+		return
+	}
+	r.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(remote.Handle(r.routes, &rr))
+}
+
+func (r *receiver) fail(status, times int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failStatus, r.failures = status, times
+}
+
+func (r *receiver) commands() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.requests, ",")
+}
+
+func newTestRouteManager(t *testing.T, r *receiver, config *Config) *RouteManager {
+	old := retryBackoff
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = old })
+	config.URL = r.server.URL
+	if r.secret != "" {
+		config.SecretFile = filepath.Join(t.TempDir(), "secret")
+		if err := ioutil.WriteFile(config.SecretFile, []byte(r.secret+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rm, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+func TestConformance(t *testing.T) {
+	var r *receiver
+	backendtest.Run(t, &backendtest.Harness{
+		New: func(t *testing.T) backend.RouteManager {
+			r = newReceiver(t, "secret")
+			return newTestRouteManager(t, r, &Config{})
+		},
+		Routes: func(t *testing.T) map[string]string {
+			return r.routes.Routes()
+		},
+		AddForeignRoute: func(t *testing.T, ip, subnet string) {
+			r.routes.AddForeignRoute(ip, subnet)
+		},
+	})
+}
+
+func TestRetry(t *testing.T) {
+	r := newReceiver(t, "")
+	rm := newTestRouteManager(t, r, &Config{Retries: 2})
+	r.fail(http.StatusServiceUnavailable, 2)
+	if _, err := rm.Insert("10.240.0.2", "10.244.1.0/24"); err != nil {
+		t.Fatalf("Insert after two 503s: %v", err)
+	}
+	if got := r.commands(); got != "insert,insert,insert" {
+		t.Errorf("receiver got %s, want three inserts", got)
+	}
+
+	r.fail(http.StatusTooManyRequests, 3)
+	if _, err := rm.Delete("10.244.1.0/24"); err == nil || !strings.Contains(err.Error(), "Too Many Requests") {
+		t.Errorf("Delete after running out of retries returned %v", err)
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	r := newReceiver(t, "")
+	rm := newTestRouteManager(t, r, &Config{Retries: 2})
+	r.fail(http.StatusBadRequest, 1)
+	_, err := rm.Insert("10.240.0.2", "10.244.1.0/24")
+	if err == nil || !strings.Contains(err.Error(), "400 Bad Request: Bad Request") {
+		t.Errorf("Insert returned %v, want the receiver's error", err)
+	}
+	if got := r.commands(); got != "insert" {
+		t.Errorf("receiver got %s, want a single insert", got)
+	}
+}
+
+func TestSyncRouteErrors(t *testing.T) {
+	r := newReceiver(t, "")
+	r.routes.AddForeignRoute("10.240.0.100", "10.244.1.0/24")
+	rm := newTestRouteManager(t, r, &Config{})
+	resp, err := rm.Sync(backend.RouteTable{"10.244.1.0/24": "10.240.0.2", "10.244.2.0/24": "10.240.0.3"})
+	if err == nil || err.Error() != "webhook: sync: 1 routes failed" {
+		t.Errorf("Sync returned %v", err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Route != "10.244.1.0/24" {
+		t.Errorf("Sync errors are %v, want one for 10.244.1.0/24", resp.Errors)
+	}
+	if len(resp.Inserted) != 1 || resp.Inserted[0] != "10.244.2.0/24" {
+		t.Errorf("Sync inserted %v", resp.Inserted)
+	}
+}
+
+func TestEmptySecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(&Config{URL: "http://127.0.0.1", SecretFile: path}); err == nil {
+		t.Error("New accepted an empty secret file")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	r := newReceiver(t, "")
+	rm := newTestRouteManager(t, r, &Config{})
+	if _, err := rm.Insert("10.240.0.9", "10.244.9.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	var planner backend.Planner = rm
+	resp, err := planner.Plan(backend.RouteTable{"10.244.9.0/24": "10.240.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Replaced) != 1 || resp.Replaced[0] != "10.244.9.0/24" {
+		t.Errorf("Plan replaces %v", resp.Replaced)
+	}
+	if got := r.routes.Routes()["10.244.9.0/24"]; got != "10.240.0.9" {
+		t.Errorf("Plan changed the next hop to %s", got)
+	}
+	if got := r.commands(); got != "insert,plan" {
+		t.Errorf("receiver got %s", got)
+	}
+}
@@ -6,10 +6,20 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/kelseyhightower/flannel-route-manager/backend"
+	"github.com/kelseyhightower/flannel-route-manager/backend/aws"
+	"github.com/kelseyhightower/flannel-route-manager/backend/azure"
+	"github.com/kelseyhightower/flannel-route-manager/backend/bgp"
+	"github.com/kelseyhightower/flannel-route-manager/backend/exec"
 	"github.com/kelseyhightower/flannel-route-manager/backend/google"
+	"github.com/kelseyhightower/flannel-route-manager/backend/memory"
+	"github.com/kelseyhightower/flannel-route-manager/backend/netlink"
+	"github.com/kelseyhightower/flannel-route-manager/backend/openstack"
+	"github.com/kelseyhightower/flannel-route-manager/backend/webhook"
 	"github.com/kelseyhightower/flannel-route-manager/server"
 )
 
@@ -18,7 +28,69 @@ var (
 	etcdEndpoint string
 	etcdPrefix   string
 	deleteRoutes bool
+	dryRun       bool
 	syncInterval int
+
+	awsClusterCIDR string
+	awsEndpoint    string
+	awsRegion      string
+	awsRouteTables string
+
+	azureADEndpoint     string
+	azureClientID       string
+	azureClientSecret   string
+	azureEndpoint       string
+	azureResourceGroup  string
+	azureRouteTable     string
+	azureSubscriptionID string
+	azureTenantID       string
+
+	bgpASN      uint
+	bgpHoldTime int
+	bgpPeers    string
+	bgpRouterID string
+
+	execPlugin  string
+	execTimeout int
+
+	googleAdopt            bool
+	googleClusterID        string
+	googleConcurrency      int
+	googleEndpoint         string
+	googleHostProject      string
+	googleInventoryRefresh int
+	googleKeyFile          string
+	googleMetadataEndpoint string
+	googleMigrateFrom      string
+	googleMigrateNames     bool
+	googleNetworks         string
+	googleNextHopInstance  bool
+	googleOperationTimeout int
+	googlePriority         int64
+	googleProject          string
+	googleRequestsPerSec   float64
+	googleRetries          int
+	googleTags             string
+
+	netlinkProtocol int
+	netlinkTable    int
+
+	openstackAuthURL     string
+	openstackClusterCIDR string
+	openstackDomainName  string
+	openstackEndpoint    string
+	openstackProjectName string
+	openstackRegion      string
+	openstackRouterID    string
+	openstackUsername    string
+
+	webhookCAFile     string
+	webhookCertFile   string
+	webhookKeyFile    string
+	webhookRetries    int
+	webhookSecretFile string
+	webhookTimeout    int
+	webhookURL        string
 )
 
 func init() {
@@ -26,7 +98,69 @@ func init() {
 	flag.StringVar(&etcdEndpoint, "etcd-endpoint", "http://127.0.0.1:4001", "etcd endpoint")
 	flag.StringVar(&etcdPrefix, "etcd-prefix", "/coreos.com/network", "etcd prefix")
 	flag.BoolVar(&deleteRoutes, "delete-all-routes", false, "delete all flannel routes")
+	flag.BoolVar(&dryRun, "dry-run", false, "log the route changes instead of making them")
 	flag.IntVar(&syncInterval, "sync-interval", 300, "sync interval")
+
+	flag.StringVar(&awsClusterCIDR, "aws-cluster-cidr", "", "aws: flannel network CIDR")
+	flag.StringVar(&awsEndpoint, "aws-endpoint", "", "aws: EC2 API endpoint")
+	flag.StringVar(&awsRegion, "aws-region", "", "aws: region (default from instance metadata)")
+	flag.StringVar(&awsRouteTables, "aws-route-tables", "", "aws: comma separated list of VPC route table IDs")
+
+	flag.StringVar(&azureADEndpoint, "azure-ad-endpoint", azure.DefaultADEndpoint, "azure: Active Directory endpoint")
+	flag.StringVar(&azureClientID, "azure-client-id", "", "azure: service principal client ID (default managed identity)")
+	flag.StringVar(&azureClientSecret, "azure-client-secret", "", "azure: service principal client secret")
+	flag.StringVar(&azureEndpoint, "azure-endpoint", azure.DefaultEndpoint, "azure: Resource Manager endpoint")
+	flag.StringVar(&azureResourceGroup, "azure-resource-group", "", "azure: resource group of the route table (default from instance metadata)")
+	flag.StringVar(&azureRouteTable, "azure-route-table", "", "azure: route table name")
+	flag.StringVar(&azureSubscriptionID, "azure-subscription-id", "", "azure: subscription ID (default from instance metadata)")
+	flag.StringVar(&azureTenantID, "azure-tenant-id", "", "azure: service principal tenant ID")
+
+	flag.UintVar(&bgpASN, "bgp-asn", 0, "bgp: local AS number")
+	flag.IntVar(&bgpHoldTime, "bgp-hold-time", 90, "bgp: hold time in seconds")
+	flag.StringVar(&bgpPeers, "bgp-peers", "", "bgp: comma separated list of peers as asn@host[:port]")
+	flag.StringVar(&bgpRouterID, "bgp-router-id", "", "bgp: router ID")
+
+	flag.StringVar(&execPlugin, "exec-plugin", "", "exec: path to the plugin program")
+	flag.IntVar(&execTimeout, "exec-timeout", 60, "exec: plugin timeout in seconds")
+
+	flag.BoolVar(&googleAdopt, "google-adopt", false, "google: take over routes named like flannel routes that carry no ownership marker")
+	flag.StringVar(&googleClusterID, "google-cluster-id", "", "google: cluster ID to make route names with, so that clusters can share a network")
+	flag.IntVar(&googleConcurrency, "google-concurrency", google.DefaultConcurrency, "google: route operations in flight at once")
+	flag.StringVar(&googleEndpoint, "google-endpoint", "", "google: Compute API base URL")
+	flag.StringVar(&googleHostProject, "google-host-project", "", "google: Shared VPC host project of the network (default the project)")
+	flag.IntVar(&googleInventoryRefresh, "google-inventory-refresh", int(google.DefaultInventoryRefresh/time.Second), "google: seconds after which the cached routes are listed again")
+	flag.StringVar(&googleKeyFile, "google-key-file", "", "google: service account JSON key file (default instance service account)")
+	flag.StringVar(&googleMetadataEndpoint, "google-metadata-endpoint", "", "google: metadata server base URL (default $GCE_METADATA_HOST or the instance's)")
+	flag.StringVar(&googleMigrateFrom, "google-migrate-from-cluster-id", "", "google: cluster ID the route names to migrate were made with")
+	flag.BoolVar(&googleMigrateNames, "google-migrate-route-names", false, "google: rename the routes named after -google-migrate-from-cluster-id and exit")
+	flag.StringVar(&googleNetworks, "google-networks", "", "google: comma separated list of network names (default from instance metadata)")
+	flag.BoolVar(&googleNextHopInstance, "google-next-hop-instance", false, "google: route to the instance owning the next hop address")
+	flag.IntVar(&googleOperationTimeout, "google-operation-timeout", int(google.DefaultOperationTimeout/time.Second), "google: route operation timeout in seconds")
+	flag.Int64Var(&googlePriority, "google-priority", google.DefaultPriority, "google: route priority")
+	flag.StringVar(&googleProject, "google-project", "", "google: project ID (default from key file or instance metadata)")
+	flag.Float64Var(&googleRequestsPerSec, "google-requests-per-second", google.DefaultRequestsPerSecond, "google: API request rate limit")
+	flag.IntVar(&googleRetries, "google-retries", google.DefaultRetries, "google: retries for rate limited and failed API requests")
+	flag.StringVar(&googleTags, "google-tags", "", "google: comma separated list of instance tags routes apply to (default all instances)")
+
+	flag.IntVar(&netlinkProtocol, "netlink-protocol", netlink.DefaultProtocol, "netlink: protocol tag of owned routes")
+	flag.IntVar(&netlinkTable, "netlink-table", netlink.DefaultTable, "netlink: routing table ID")
+
+	flag.StringVar(&openstackAuthURL, "openstack-auth-url", "", "openstack: keystone v3 URL (default $OS_AUTH_URL)")
+	flag.StringVar(&openstackClusterCIDR, "openstack-cluster-cidr", "", "openstack: flannel network CIDR")
+	flag.StringVar(&openstackDomainName, "openstack-domain-name", "", "openstack: user and project domain (default $OS_USER_DOMAIN_NAME)")
+	flag.StringVar(&openstackEndpoint, "openstack-endpoint", "", "openstack: neutron endpoint (default from service catalog)")
+	flag.StringVar(&openstackProjectName, "openstack-project-name", "", "openstack: project name (default $OS_PROJECT_NAME)")
+	flag.StringVar(&openstackRegion, "openstack-region", "", "openstack: region (default $OS_REGION_NAME)")
+	flag.StringVar(&openstackRouterID, "openstack-router-id", "", "openstack: neutron router ID")
+	flag.StringVar(&openstackUsername, "openstack-username", "", "openstack: user name (default $OS_USERNAME)")
+
+	flag.StringVar(&webhookCAFile, "webhook-ca-file", "", "webhook: CA certificate file to verify the receiver")
+	flag.StringVar(&webhookCertFile, "webhook-cert-file", "", "webhook: TLS client certificate file")
+	flag.StringVar(&webhookKeyFile, "webhook-key-file", "", "webhook: TLS client key file")
+	flag.IntVar(&webhookRetries, "webhook-retries", webhook.DefaultRetries, "webhook: retries for failed requests")
+	flag.StringVar(&webhookSecretFile, "webhook-secret-file", "", "webhook: file holding the HMAC signing key")
+	flag.IntVar(&webhookTimeout, "webhook-timeout", 30, "webhook: request timeout in seconds")
+	flag.StringVar(&webhookURL, "webhook-url", "", "webhook: receiver URL")
 }
 
 func main() {
@@ -36,13 +170,158 @@ func main() {
 	var err error
 	switch backendName {
 	case "google":
-		routeManager, err = google.New()
+		routeManager, err = google.New(&google.Config{
+			Adopt:             googleAdopt,
+			ClusterID:         googleClusterID,
+			Concurrency:       googleConcurrency,
+			Endpoint:          googleEndpoint,
+			HostProject:       googleHostProject,
+			InventoryRefresh:  time.Duration(googleInventoryRefresh) * time.Second,
+			KeyFile:           googleKeyFile,
+			MetadataEndpoint:  googleMetadataEndpoint,
+			Networks:          splitList(googleNetworks),
+			NextHopInstance:   googleNextHopInstance,
+			OperationTimeout:  time.Duration(googleOperationTimeout) * time.Second,
+			Priority:          &googlePriority,
+			Project:           googleProject,
+			RequestsPerSecond: googleRequestsPerSec,
+			Retries:           googleRetries,
+			Tags:              splitList(googleTags),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "aws":
+		routeManager, err = aws.New(&aws.Config{
+			ClusterCIDR: awsClusterCIDR,
+			Endpoint:    awsEndpoint,
+			Region:      awsRegion,
+			RouteTables: splitList(awsRouteTables),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "azure":
+		routeManager, err = azure.New(&azure.Config{
+			ADEndpoint:     azureADEndpoint,
+			ClientID:       azureClientID,
+			ClientSecret:   azureClientSecret,
+			Endpoint:       azureEndpoint,
+			ResourceGroup:  azureResourceGroup,
+			RouteTable:     azureRouteTable,
+			SubscriptionID: azureSubscriptionID,
+			TenantID:       azureTenantID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "bgp":
+		var peers []bgp.Peer
+		for _, p := range splitList(bgpPeers) {
+			peer, err := bgp.ParsePeer(p)
+			if err != nil {
+				log.Fatal(err)
+			}
+			peers = append(peers, peer)
+		}
+		routeManager, err = bgp.New(&bgp.Config{
+			ASN:      uint32(bgpASN),
+			HoldTime: time.Duration(bgpHoldTime) * time.Second,
+			Peers:    peers,
+			RouterID: bgpRouterID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "exec":
+		routeManager, err = exec.New(&exec.Config{
+			Path:    execPlugin,
+			Args:    flag.Args(),
+			Timeout: time.Duration(execTimeout) * time.Second,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "memory":
+		routeManager = memory.New()
+	case "netlink":
+		routeManager, err = netlink.New(&netlink.Config{
+			Protocol: netlinkProtocol,
+			Table:    netlinkTable,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "openstack":
+		routeManager, err = openstack.New(&openstack.Config{
+			AuthURL:     openstackAuthURL,
+			ClusterCIDR: openstackClusterCIDR,
+			DomainName:  openstackDomainName,
+			Endpoint:    openstackEndpoint,
+			ProjectName: openstackProjectName,
+			Region:      openstackRegion,
+			RouterID:    openstackRouterID,
+			Username:    openstackUsername,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "webhook":
+		routeManager, err = webhook.New(&webhook.Config{
+			CAFile:     webhookCAFile,
+			CertFile:   webhookCertFile,
+			KeyFile:    webhookKeyFile,
+			Retries:    webhookRetries,
+			SecretFile: webhookSecretFile,
+			Timeout:    time.Duration(webhookTimeout) * time.Second,
+			URL:        webhookURL,
+		})
 		if err != nil {
 			log.Fatal(err)
 		}
 	default:
 		log.Fatal("unknown backend ", backendName)
 	}
+	var planner backend.Planner
+	if dryRun {
+		var ok bool
+		if planner, ok = routeManager.(backend.Planner); !ok {
+			log.Fatalf("backend %s does not support -dry-run", backendName)
+		}
+	}
+	if googleMigrateNames {
+		rm, ok := routeManager.(*google.RouteManager)
+		if !ok {
+			log.Fatalf("backend %s does not support -google-migrate-route-names", backendName)
+		}
+		resp, err := rm.MigrateRouteNames(googleMigrateFrom, dryRun)
+		if dryRun {
+			server.LogPlan("migrate-route-names", resp)
+		} else {
+			for _, r := range resp.Inserted {
+				log.Printf("inserted: %s\n", r)
+			}
+			for _, r := range resp.Deleted {
+				log.Printf("deleted: %s\n", r)
+			}
+			for _, e := range resp.Errors {
+				log.Println(e.Error())
+			}
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if deleteRoutes && dryRun {
+		resp, err := planner.Plan(backend.RouteTable{})
+		if err != nil && (resp == nil || len(resp.Errors) == 0) {
+			log.Fatal(err)
+		}
+		server.LogPlan("delete-all-routes", resp)
+		os.Exit(0)
+	}
 	if deleteRoutes {
 		log.Println("deleting all routes")
 		routes, err := routeManager.DeleteAllRoutes()
@@ -58,10 +337,25 @@ func main() {
 		os.Exit(0)
 	}
 	log.Println("starting fleet route manager...")
-	s := server.New(etcdEndpoint, etcdPrefix, syncInterval, routeManager).Start()
+	s := server.New(etcdEndpoint, etcdPrefix, syncInterval, routeManager)
+	if dryRun {
+		log.Println("dry run, no routes will be changed")
+		s.DryRun(planner)
+	}
+	s.Start()
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 	c := <-signalChan
 	log.Println(fmt.Sprintf("captured %v exiting...", c))
 	s.Stop()
 }
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kelseyhightower/flannel-route-manager/backend"
+)
+
+type plan struct {
+	Time      time.Time   `json:"time"`
+	Source    string      `json:"source"`
+	Inserted  []string    `json:"inserted"`
+	Replaced  []string    `json:"replaced"`
+	Deleted   []string    `json:"deleted"`
+	Unchanged int         `json:"unchanged"`
+	Errors    []planError `json:"errors"`
+}
+
+// planError is a route the changes would fail for, or leave unrouted.
+type planError struct {
+	Route string `json:"route"`
+	Error string `json:"error"`
+}
+
+// LogPlan logs the changes a dry run would have made and prints them as a
+// single line of JSON to stdout.
+func LogPlan(source string, resp *backend.SyncResponse) {
+	p := plan{
+		Time:      time.Now().UTC(),
+		Source:    source,
+		Inserted:  nonNil(resp.Inserted),
+		Replaced:  nonNil(resp.Replaced),
+		Deleted:   nonNil(resp.Deleted),
+		Unchanged: len(resp.Unchanged),
+		Errors:    []planError{},
+	}
+	for _, e := range resp.Errors {
+		p.Errors = append(p.Errors, planError{Route: e.Route, Error: e.Err.Error()})
+	}
+	for _, r := range p.Deleted {
+		log.Printf("%s: would delete %s\n", source, r)
+	}
+	for _, r := range p.Replaced {
+		log.Printf("%s: would replace %s\n", source, r)
+	}
+	for _, r := range p.Inserted {
+		log.Printf("%s: would insert %s\n", source, r)
+	}
+	for _, e := range p.Errors {
+		log.Printf("%s: would fail %s: %s\n", source, e.Route, e.Error)
+	}
+	log.Printf("%s: plan: %d to insert, %d to replace, %d to delete, %d unchanged, %d failing\n",
+		source, len(p.Inserted), len(p.Replaced), len(p.Deleted), p.Unchanged, len(p.Errors))
+	data, err := json.Marshal(p)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	fmt.Println(string(data))
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,13 +14,15 @@ import (
 )
 
 type routeInfo struct {
-	PublicIP string
+	PublicIP    string
+	Annotations map[string]string
 }
 
 type Server struct {
 	client       *etcd.Client
 	lastIndex    uint64
 	mu           sync.Mutex
+	planner      backend.Planner
 	prefix       string
 	routeManager backend.RouteManager
 	stopChan     chan bool
@@ -37,6 +40,13 @@ func New(etcdEndpoint, prefix string, syncInterval int, routeManager backend.Rou
 	}
 }
 
+// DryRun makes the server log the changes it would make instead of making
+// them. The backend is only ever asked to plan.
+func (s *Server) DryRun(planner backend.Planner) *Server {
+	s.planner = planner
+	return s
+}
+
 func (s *Server) Start() *Server {
 	s.syncAllRoutes()
 	go s.monitorSubnets()
@@ -52,7 +62,7 @@ func (s *Server) Stop() {
 func (s *Server) syncAllRoutes() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	routeTable := make(map[string]string)
+	routeTable := make(backend.RouteTable)
 	resp, err := s.client.Get(s.prefix, false, true)
 	if err != nil {
 		return err
@@ -65,18 +75,34 @@ func (s *Server) syncAllRoutes() error {
 		if err != nil {
 			return err
 		}
-		routeTable[ri.PublicIP] = subnet
+		routeTable[subnet] = ri.PublicIP
+		s.annotate(subnet, ri.Annotations, node.ModifiedIndex)
 	}
 	log.Printf("reconciler starting...")
 	defer log.Printf("reconciler done")
+	if s.planner != nil {
+		planResp, err := s.planner.Plan(routeTable)
+		if err != nil && (planResp == nil || len(planResp.Errors) == 0) {
+			return err
+		}
+		// Route errors are part of the plan.
+		LogPlan("reconciler", planResp)
+		return nil
+	}
 	syncResp, err := s.routeManager.Sync(routeTable)
-	if resp != nil {
+	if syncResp != nil {
 		for _, r := range syncResp.Inserted {
 			log.Printf("reconciler: inserted %s\n", r)
 		}
+		for _, r := range syncResp.Replaced {
+			log.Printf("reconciler: replaced %s\n", r)
+		}
 		for _, r := range syncResp.Deleted {
 			log.Printf("reconciler: deleted %s\n", r)
 		}
+		for _, e := range syncResp.Errors {
+			log.Printf("reconciler: failed %s\n", e)
+		}
 	}
 	if err != nil {
 		return err
@@ -96,6 +122,11 @@ func (s *Server) syncRoute(resp *etcd.Response) {
 			log.Println(err.Error())
 			return
 		}
+		s.annotate(subnet, ri.Annotations, resp.Node.ModifiedIndex)
+		if s.planner != nil {
+			LogPlan("monitor", &backend.SyncResponse{Inserted: []string{subnet + " via " + ri.PublicIP}})
+			return
+		}
 		name, err := s.routeManager.Insert(ri.PublicIP, subnet)
 		if err != nil {
 			log.Println(err.Error())
@@ -103,6 +134,11 @@ func (s *Server) syncRoute(resp *etcd.Response) {
 		}
 		log.Printf("monitor: inserted %s\n", name)
 	case "delete":
+		s.clearAnnotations(subnet)
+		if s.planner != nil {
+			LogPlan("monitor", &backend.SyncResponse{Deleted: []string{subnet}})
+			return
+		}
 		name, err := s.routeManager.Delete(subnet)
 		if err != nil {
 			log.Println(err.Error())
@@ -114,6 +150,27 @@ func (s *Server) syncRoute(resp *etcd.Response) {
 	}
 }
 
+// annotate passes the annotations of a subnet lease, and the etcd index it
+// was modified at, on to backends that use them.
+func (s *Server) annotate(subnet string, annotations map[string]string, index uint64) {
+	a, ok := s.routeManager.(backend.Annotator)
+	if !ok {
+		return
+	}
+	m := make(map[string]string)
+	for k, v := range annotations {
+		m[k] = v
+	}
+	m[backend.EtcdIndexAnnotation] = strconv.FormatUint(index, 10)
+	a.Annotate(subnet, m)
+}
+
+func (s *Server) clearAnnotations(subnet string) {
+	if a, ok := s.routeManager.(backend.Annotator); ok {
+		a.Annotate(subnet, nil)
+	}
+}
+
 func (s *Server) monitorSubnets() {
 	s.wg.Add(1)
 	defer s.wg.Done()